@@ -0,0 +1,192 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Captures log all serial traffic to and from a device, one line per read or
+// write with a timestamp and direction:
+//
+//	2018-11-18T00:11:39.123456789-05:00 > 7d 81 a7 80 80 80 80 80 80
+//	2018-11-18T00:11:39.125912874-05:00 < 0c 80 80 80 80 80 80 80
+//
+// Lines starting with # are comments.
+const (
+	CaptureWrite = ">"
+	CaptureRead  = "<"
+)
+
+// Capturer is implemented by devices that can log their serial traffic. It
+// must be called before Connect.
+type Capturer interface {
+	SetCapture(w io.Writer)
+}
+
+// CaptureReadWriter logs all traffic to and from a device
+type CaptureReadWriter struct {
+	rw io.ReadWriter
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func NewCaptureReadWriter(rw io.ReadWriter, w io.Writer) *CaptureReadWriter {
+	return &CaptureReadWriter{rw: rw, w: w}
+}
+
+func (c *CaptureReadWriter) Read(p []byte) (int, error) {
+	n, err := c.rw.Read(p)
+	if n > 0 {
+		c.log(CaptureRead, p[:n])
+	}
+
+	return n, err
+}
+
+func (c *CaptureReadWriter) Write(p []byte) (int, error) {
+	n, err := c.rw.Write(p)
+	if n > 0 {
+		c.log(CaptureWrite, p[:n])
+	}
+
+	return n, err
+}
+
+func (c *CaptureReadWriter) Flush() error {
+	return flush(c.rw)
+}
+
+func (c *CaptureReadWriter) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func (c *CaptureReadWriter) log(direction string, p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(c.w, "%s %s % x\n", time.Now().Format(time.RFC3339Nano), direction, p)
+}
+
+type captureEntry struct {
+	direction string
+	data      []byte
+}
+
+// Replay plays back a capture in place of a device. Writes must match the
+// next write in the capture. Reads return the captured reads up to the next
+// write, then io.EOF as if the device timed out.
+type Replay struct {
+	entries []*captureEntry
+	pos     int
+	pending []byte
+}
+
+func NewReplay(r io.Reader) (*Replay, error) {
+	replay := &Replay{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 3 || (fields[1] != CaptureWrite && fields[1] != CaptureRead) {
+			return nil, fmt.Errorf("Invalid capture line %d: %s", line, text)
+		}
+
+		data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid capture line %d: %s", line, err)
+		}
+
+		replay.entries = append(replay.entries, &captureEntry{direction: fields[1], data: data})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return replay, nil
+}
+
+// OpenReplay opens a capture file for replay
+func OpenReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewReplay(f)
+}
+
+func (r *Replay) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.pos >= len(r.entries) || r.entries[r.pos].direction != CaptureRead {
+			return 0, io.EOF
+		}
+
+		r.pending = r.entries[r.pos].data
+		r.pos++
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+
+	return n, nil
+}
+
+func (r *Replay) Close() error {
+	return nil
+}
+
+func (r *Replay) Write(p []byte) (int, error) {
+	// Any reads the driver didn't consume before this write are dropped
+	r.pending = nil
+	for r.pos < len(r.entries) && r.entries[r.pos].direction == CaptureRead {
+		r.pos++
+	}
+
+	if r.pos >= len(r.entries) {
+		return 0, fmt.Errorf("Replay has no more writes. Got % x", p)
+	}
+
+	entry := r.entries[r.pos]
+	if !bytes.Equal(entry.data, p) {
+		return 0, fmt.Errorf("Replay write mismatch. Got % x expected % x", p, entry.data)
+	}
+
+	r.pos++
+
+	return len(p), nil
+}
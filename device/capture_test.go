@@ -0,0 +1,176 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newReplayDevice(t *testing.T, path string) *CMS50 {
+	cms := &CMS50{}
+	err := cms.Connect(context.Background(), "file://"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cms
+}
+
+func TestCapture(t *testing.T) {
+	var buf bytes.Buffer
+
+	cms := &CMS50{}
+	cms.device = NewCaptureReadWriter(&MockCMS50{}, &buf)
+
+	err := cms.ResetDevice(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Invalid number of capture lines: got '%d' should be '%d'", len(lines), 4)
+	}
+
+	fields := strings.Fields(lines[0])
+	_, err = time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		t.Errorf("Invalid capture timestamp: %s", err)
+	}
+
+	if fields[1] != CaptureWrite || strings.Join(fields[2:], " ") != "7d 81 a7 80 80 80 80 80 80" {
+		t.Errorf("Invalid capture line: %s", lines[0])
+	}
+
+	if !strings.Contains(lines[1], CaptureRead+" 0c 80") {
+		t.Errorf("Invalid capture line: %s", lines[1])
+	}
+
+	// Replaying the capture should give the same result
+	replay, err := NewReplay(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cms = &CMS50{device: replay}
+	err = cms.ResetDevice(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// TestReplayImport replays the commands sent by the import command
+func TestReplayImport(t *testing.T) {
+	cms := newReplayDevice(t, "testdata/cms50f-import.cap")
+
+	err := cms.ResetDevice(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validStart := time.Date(2018, time.November, 18, 0, 11, 39, 0, time.Local)
+
+	now, err := cms.GetTime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if now != validStart {
+		t.Errorf("Invalid device time: got '%s' should be '%s'", now, validStart)
+	}
+
+	count, err := cms.GetSessionCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("Invalid session count: got '%d' should be '%d'", count, 1)
+	}
+
+	identity, err := cms.GetIdentity(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Model != "50F" || identity.DeviceID != "0154321" {
+		t.Errorf("Invalid identity: got '%+v'", *identity)
+	}
+
+	duration, err := cms.GetSessionDuration(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if duration != 15*time.Second {
+		t.Errorf("Invalid session duration: got '%s' should be '%s'", duration, 15*time.Second)
+	}
+
+	start, err := cms.GetSessionTime(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if start != validStart {
+		t.Errorf("Invalid session time: got '%s' should be '%s'", start, validStart)
+	}
+
+	data, err := cms.GetSessionData(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 15 {
+		t.Errorf("Invalid session data: got '%d' should be '%d'", len(data), 15)
+	}
+}
+
+func TestReplaySessionDataSplit(t *testing.T) {
+	cms := newReplayDevice(t, "testdata/cms50f-session-data-split.cap")
+
+	data, err := cms.GetSessionData(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 15 {
+		t.Errorf("Invalid session data: got '%d' should be '%d'", len(data), 15)
+	}
+}
+
+func TestReplayBadSessionData(t *testing.T) {
+	cms := newReplayDevice(t, "testdata/cms50f-bad-session-data.cap")
+
+	_, err := cms.GetSessionData(context.Background(), 0)
+	if err == nil || !strings.Contains(err.Error(), "Unknown result for CommandGetSessionData") {
+		t.Errorf("Expected unknown result error for bad session data. Got: %v", err)
+	}
+}
+
+func TestReplayWriteMismatch(t *testing.T) {
+	cms := newReplayDevice(t, "testdata/cms50f-import.cap")
+
+	_, err := cms.GetSessionCount(context.Background())
+	if err == nil {
+		t.Errorf("Expected error for command that doesn't match the capture")
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// The CMS50D+ and CMS50E (and older firmware CMS50 models) use a simpler
+// protocol than the CMS50F. The device streams live data as soon as it's
+// connected and the memory is uploaded as a single dump with no session
+// queries. The upload is a series of 3 byte frames:
+//
+//	0xf2 hour minute   start time of the recording
+//	0xf0 pulse spo2    one sample. The low bit of the sync byte is the high
+//	                   bit of the pulse. Pulse 0xff is finger out
+//
+// All bytes after the sync byte have the high bit set.
+const (
+	CMS50DBaud            = 19200
+	CMS50DCommandUpload   = 0xf5
+	CMS50DFrameHeader     = 0xf2
+	CMS50DFrameSample     = 0xf0
+	CMS50DLiveDataRate    = 60
+	CMS50DDefaultModel    = "CMS50D+"
+	CMS50DDefaultVendor   = "CONTEC"
+	CMS50DDefaultInterval = time.Second
+	CMS50DMaxSkipBytes    = 1024
+)
+
+type CMS50D struct {
+	device  io.ReadWriter
+	capture io.Writer
+
+	// Model reported for sessions downloaded from the device
+	Model string
+
+	// How often the device stores a sample in memory
+	Interval time.Duration
+
+	upload *cms50dUpload
+}
+
+// cms50dUpload is the single session uploaded from the device memory
+type cms50dUpload struct {
+	start   time.Time
+	records []*model.OxiRecord
+}
+
+func init() {
+	Register("cms50d", func() Device { return NewCMS50D() })
+}
+
+func NewCMS50D() *CMS50D {
+	return &CMS50D{Model: CMS50DDefaultModel, Interval: CMS50DDefaultInterval}
+}
+
+func (c *CMS50D) SetCapture(w io.Writer) {
+	c.capture = w
+}
+
+func (c *CMS50D) Connect(ctx context.Context, port string) error {
+	interval, err := portInterval(port, c.Interval)
+	if err != nil {
+		return err
+	}
+	c.Interval = interval
+
+	dev, err := connect(ctx, port, TransportConfig{Baud: CMS50DBaud, ReadTimeout: time.Second * 5}, c.capture)
+	if err != nil {
+		return err
+	}
+
+	c.device = dev
+	return nil
+}
+
+func (c *CMS50D) Close() error {
+	if closer, ok := c.device.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// ResetDevice discards any previous upload and checks the device is sending
+// live data
+func (c *CMS50D) ResetDevice(ctx context.Context) error {
+	c.upload = nil
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64)
+	read, err := c.device.Read(buf)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if read == 0 {
+		return ErrTimeout
+	}
+
+	for _, b := range buf[:read] {
+		if b&0x80 != 0 {
+			return nil
+		}
+	}
+
+	return &UnexpectedHeaderError{Command: "live data", Packet: buf[:read]}
+}
+
+func (c *CMS50D) GetModel(ctx context.Context) (string, error) {
+	return c.Model, nil
+}
+
+func (c *CMS50D) GetUser(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (c *CMS50D) GetIdentity(ctx context.Context) (*DeviceIdentity, error) {
+	return &DeviceIdentity{Vendor: CMS50DDefaultVendor, Model: c.Model}, nil
+}
+
+func (c *CMS50D) GetTime(ctx context.Context) (time.Time, error) {
+	return time.Time{}, ErrNotSupported
+}
+
+func (c *CMS50D) SetTime(ctx context.Context, t time.Time) error {
+	return ErrNotSupported
+}
+
+func (c *CMS50D) EraseSessions(ctx context.Context) error {
+	return ErrNotSupported
+}
+
+// GetSessionCount uploads the device memory and returns 1 if it contains a
+// session
+func (c *CMS50D) GetSessionCount(ctx context.Context) (uint8, error) {
+	upload, err := c.getUpload(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(upload.records) == 0 {
+		return 0, nil
+	}
+
+	return 1, nil
+}
+
+func (c *CMS50D) GetSessionDuration(ctx context.Context, session uint8) (time.Duration, error) {
+	upload, err := c.getSession(ctx, session)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(len(upload.records)) * c.Interval, nil
+}
+
+// GetSampleInterval returns the Interval field. The device stores a single
+// session and does not report its sample interval.
+func (c *CMS50D) GetSampleInterval(ctx context.Context, session uint8) (time.Duration, error) {
+	return c.Interval, nil
+}
+
+func (c *CMS50D) GetSessionTime(ctx context.Context, session uint8) (time.Time, error) {
+	upload, err := c.getSession(ctx, session)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return upload.start, nil
+}
+
+func (c *CMS50D) GetSessionData(ctx context.Context, session uint8) ([]*model.OxiRecord, error) {
+	upload, err := c.getSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return upload.records, nil
+}
+
+// StreamSessionData passes each record of the uploaded session to handler.
+// The device uploads its whole memory at once so records are only streamed
+// after the upload completes.
+func (c *CMS50D) StreamSessionData(ctx context.Context, session uint8, handler RecordHandler) error {
+	upload, err := c.getSession(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range upload.records {
+		err := handler(rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *CMS50D) getSession(ctx context.Context, session uint8) (*cms50dUpload, error) {
+	upload, err := c.getUpload(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if session != 0 || len(upload.records) == 0 {
+		return nil, fmt.Errorf("Session %d not found on device", session)
+	}
+
+	return upload, nil
+}
+
+func (c *CMS50D) getUpload(ctx context.Context) (*cms50dUpload, error) {
+	if c.upload != nil {
+		return c.upload, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err := c.device.Write([]byte{CMS50DCommandUpload, CMS50DCommandUpload})
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := c.readUpload(ctx, bufio.NewReader(c.device), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Uploaded %d records starting at %s", len(upload.records), upload.start)
+
+	c.upload = upload
+	return c.upload, nil
+}
+
+func (c *CMS50D) readUpload(ctx context.Context, reader *bufio.Reader, now time.Time) (*cms50dUpload, error) {
+	// Skip any live data still buffered until we find the upload header
+	var hour, minute uint8
+	for skipped := 0; ; skipped++ {
+		if skipped > CMS50DMaxSkipBytes {
+			return nil, fmt.Errorf("Upload header not found. Is the device in upload mode?")
+		}
+
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return &cms50dUpload{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if b != CMS50DFrameHeader {
+			continue
+		}
+
+		buf := make([]byte, 2)
+		_, err = io.ReadFull(reader, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		hour, minute = buf[0]&0x7f, buf[1]&0x7f
+		break
+	}
+
+	records := make([]*model.OxiRecord, 0)
+	buf := make([]byte, 3)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		n, err := io.ReadFull(reader, buf)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			return nil, &ShortReadError{Command: "upload", Want: len(buf), Got: n}
+		} else if err != nil {
+			return nil, err
+		}
+
+		if buf[0]&0xfe != CMS50DFrameSample {
+			return nil, &UnexpectedHeaderError{Command: "upload", Packet: buf}
+		}
+
+		pulse := (buf[1] & 0x7f) | ((buf[0] & 0x01) << 7)
+		spo2 := buf[2] & 0x7f
+
+		records = append(records, c.newOxiRecord(pulse, spo2))
+	}
+
+	duration := time.Duration(len(records)) * c.Interval
+
+	return &cms50dUpload{start: c.startTime(now, hour, minute, duration), records: records}, nil
+}
+
+// startTime returns the most recent time at hour:minute that allows a
+// recording of duration to have finished by now. The device only stores the
+// time of day the recording started.
+func (c *CMS50D) startTime(now time.Time, hour, minute uint8, duration time.Duration) time.Time {
+	start := time.Date(now.Year(), now.Month(), now.Day(), int(hour), int(minute), 0, 0, time.Local)
+	for start.Add(duration).After(now) {
+		start = start.AddDate(0, 0, -1)
+	}
+
+	return start
+}
+
+func (c *CMS50D) newOxiRecord(pulse, spo2 uint8) *model.OxiRecord {
+	if pulse == 0xff || spo2 == 0x7f {
+		return &model.OxiRecord{Status: model.StatusFingerOut}
+	}
+
+	return &model.OxiRecord{Pulse: pulse, Spo2: spo2}
+}
+
+// StreamLiveData streams real-time data from the device until ctx is done.
+// Live data is sent as 5 byte packets with the high bit set only on the first
+// byte:
+//
+//	0  bit 6 beep, bit 4 searching too long, low nibble signal strength
+//	1  plethysmograph
+//	2  bit 6 is the high bit of the pulse, bit 5 searching, bit 4 probe
+//	   error, low nibble is the bar graph
+//	3  pulse
+//	4  spo2
+func (c *CMS50D) StreamLiveData(ctx context.Context, handler RecordHandler, waveform WaveformHandler) error {
+	reader := bufio.NewReader(c.device)
+	start := time.Now()
+	packets := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debugf("Stopping live data stream after %d packets", packets)
+			return nil
+		default:
+		}
+
+		buf, err := c.readLivePacket(reader)
+		if err == io.EOF {
+			log.Warn("Device stopped sending live data")
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		log.Debugf("Got new live data packet: % x", buf)
+
+		pulse := buf[3] | ((buf[2] & 0x40) << 1)
+		spo2 := buf[4]
+
+		if waveform != nil && pulse != 0xff {
+			sample := &model.WaveformSample{
+				DateTime: start.Add(time.Second * time.Duration(packets) / CMS50DLiveDataRate),
+				Pleth:    buf[1],
+			}
+			err := waveform(sample)
+			if err != nil {
+				return err
+			}
+		}
+
+		if packets%CMS50DLiveDataRate == 0 {
+			rec := c.newOxiRecord(pulse, spo2)
+			if rec.Valid() {
+				rec.Status = c.liveStatus(buf)
+			}
+			rec.DateTime = start.Add(time.Second * time.Duration(packets/CMS50DLiveDataRate))
+			err := handler(rec)
+			if err != nil {
+				return err
+			}
+		}
+
+		packets++
+	}
+}
+
+// liveStatus returns the quality flags of a live data packet
+func (c *CMS50D) liveStatus(buf []byte) model.Status {
+	status := model.StatusValid
+	if buf[2]&0x10 != 0 {
+		status |= model.StatusProbeError
+	}
+	if buf[2]&0x20 != 0 || buf[0]&0x10 != 0 {
+		status |= model.StatusSearching
+	}
+	if buf[0]&0x0f == 0 {
+		status |= model.StatusLowPerfusion
+	}
+
+	return status
+}
+
+func (c *CMS50D) readLivePacket(reader *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if b&0x80 == 0 {
+			log.Debugf("Skipping unexpected byte in live data stream: %x", b)
+			continue
+		}
+
+		buf := make([]byte, 5)
+		buf[0] = b
+		_, err = io.ReadFull(reader, buf[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return buf, nil
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+type MockCMS50D struct {
+	uploading bool
+}
+
+func (c *MockCMS50D) Read(p []byte) (int, error) {
+	var res []byte
+	if c.uploading {
+		res = []byte{
+			// Leftover live data before the upload starts
+			0xc5, 0x30, 0x05, 0x40, 0x61,
+			0xf2, 0x96, 0xab,
+			0xf0, 0xbe, 0xe2,
+			0xf0, 0xbf, 0xe1,
+			0xf1, 0x82, 0xe0,
+			0xf1, 0xff, 0xff,
+			0xf0, 0xc0, 0xe1,
+		}
+	} else {
+		res = mockCMS50DLiveData()
+	}
+
+	n := copy(p, res)
+
+	return n, io.EOF
+}
+
+func (c *MockCMS50D) Write(p []byte) (int, error) {
+	if bytes.Equal(p, []byte{CMS50DCommandUpload, CMS50DCommandUpload}) {
+		c.uploading = true
+	}
+	return len(p), nil
+}
+
+// mockCMS50DLiveData returns a little over one second of live data packets
+// with a pulse of 130 to check the pulse high bit. The device is searching
+// for a pulse in the second second.
+func mockCMS50DLiveData() []byte {
+	data := []byte{0x01, 0x02}
+	for i := 0; i < CMS50DLiveDataRate+5; i++ {
+		flags := uint8(0x45)
+		if i >= CMS50DLiveDataRate {
+			flags |= 0x20
+		}
+		data = append(data, 0xc5, uint8(i%100), flags, 130&0x7f, 96)
+	}
+
+	return data
+}
+
+func newTestCMS50D() *CMS50D {
+	cms := NewCMS50D()
+	cms.device = &MockCMS50D{}
+
+	if testing.Verbose() {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	return cms
+}
+
+func TestCMS50DReset(t *testing.T) {
+	cms := newTestCMS50D()
+	err := cms.ResetDevice(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCMS50DGetSessionData(t *testing.T) {
+	cms := newTestCMS50D()
+	count, err := cms.GetSessionCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("Invalid session count: got '%d' should be '%d'", count, 1)
+	}
+
+	data, err := cms.GetSessionData(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []*model.OxiRecord{
+		&model.OxiRecord{Pulse: 62, Spo2: 98},
+		&model.OxiRecord{Pulse: 63, Spo2: 97},
+		&model.OxiRecord{Pulse: 130, Spo2: 96},
+		&model.OxiRecord{Status: model.StatusFingerOut},
+		&model.OxiRecord{Pulse: 64, Spo2: 97},
+	}
+
+	if len(data) != len(valid) {
+		t.Fatalf("Invalid session data: got '%d' should be '%d'", len(data), len(valid))
+	}
+
+	for i := range valid {
+		if data[i].Pulse != valid[i].Pulse || data[i].Spo2 != valid[i].Spo2 || data[i].Status != valid[i].Status {
+			t.Errorf("Invalid record %d: got '%s' should be '%s'", i, data[i], valid[i])
+		}
+	}
+
+	duration, err := cms.GetSessionDuration(context.Background(), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if duration != 5*time.Second {
+		t.Errorf("Invalid session duration: got '%s' should be '%s'", duration, 5*time.Second)
+	}
+
+	start, err := cms.GetSessionTime(context.Background(), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if start.Hour() != 22 || start.Minute() != 43 {
+		t.Errorf("Invalid session time: got '%s' should be at '%s'", start, "22:43")
+	}
+
+	_, err = cms.GetSessionData(context.Background(), 1)
+	if err == nil {
+		t.Errorf("Expected error fetching session that doesn't exist")
+	}
+}
+
+func TestCMS50DStartTime(t *testing.T) {
+	cms := NewCMS50D()
+	now := time.Date(2018, time.November, 24, 8, 0, 0, 0, time.Local)
+
+	start := cms.startTime(now, 23, 30, 8*time.Hour)
+	valid := time.Date(2018, time.November, 23, 23, 30, 0, 0, time.Local)
+	if start != valid {
+		t.Errorf("Invalid start time: got '%s' should be '%s'", start, valid)
+	}
+
+	start = cms.startTime(now, 1, 15, 6*time.Hour)
+	valid = time.Date(2018, time.November, 24, 1, 15, 0, 0, time.Local)
+	if start != valid {
+		t.Errorf("Invalid start time: got '%s' should be '%s'", start, valid)
+	}
+}
+
+func TestCMS50DReadUploadNoData(t *testing.T) {
+	cms := NewCMS50D()
+	upload, err := cms.readUpload(context.Background(), bufio.NewReader(bytes.NewReader([]byte{})), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(upload.records) != 0 {
+		t.Errorf("Invalid empty upload: got '%d' records should be '%d'", len(upload.records), 0)
+	}
+}
+
+func TestCMS50DStreamLiveData(t *testing.T) {
+	cms := newTestCMS50D()
+
+	data := make([]*model.OxiRecord, 0)
+	samples := 0
+	err := cms.StreamLiveData(context.Background(), func(rec *model.OxiRecord) error {
+		data = append(data, rec)
+		return nil
+	}, func(sample *model.WaveformSample) error {
+		samples++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(data) != 2 {
+		t.Fatalf("Invalid live data: got '%d' records should be '%d'", len(data), 2)
+	}
+
+	if data[0].Pulse != 130 || data[0].Spo2 != 96 {
+		t.Errorf("Invalid live record: got '%s' should be '%s'", data[0], &model.OxiRecord{Pulse: 130, Spo2: 96})
+	}
+
+	if !data[0].Valid() || data[1].Status != model.StatusSearching {
+		t.Errorf("Invalid live record status: got '%s' and '%s'", data[0].Status, data[1].Status)
+	}
+
+	if samples != CMS50DLiveDataRate+5 {
+		t.Errorf("Invalid waveform data: got '%d' samples should be '%d'", samples, CMS50DLiveDataRate+5)
+	}
+}
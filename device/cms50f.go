@@ -20,15 +20,14 @@ package device
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aebruno/myoxi/model"
 	log "github.com/sirupsen/logrus"
-	"github.com/tarm/serial"
 )
 
 const (
@@ -44,21 +43,47 @@ const (
 	CommandGetOximeterModel    = 0xa8
 	CommandGetOximeterVendor   = 0xa9
 	CommandSessionErase        = 0xae
+	CommandLiveDataStart       = 0xa1
+	CommandLiveDataStop        = 0xa2
+	CommandKeepAlive           = 0xaf
+	CommandGetDateTime         = 0xb1
+	CommandSetTime             = 0xb2
+	CommandSetDate             = 0xb3
 	DurationDivisor            = 2
+	CMS50DefaultInterval       = time.Second
+	LiveDataRate               = 60
+	KeepAliveInterval          = 5 * time.Second
 )
 
 type CMS50 struct {
 	device       io.ReadWriter
+	capture      io.Writer
 	model        string
 	user         string
+	vendor       string
+	deviceID     string
+	info         string
 	sessionCount uint8
+	retry        *RetryPolicy
+
+	// How often the device stores a sample in memory. Defaults to
+	// CMS50DefaultInterval
+	Interval time.Duration
+}
+
+func init() {
+	Register("cms50f", func() Device { return &CMS50{} })
 }
 
 func (c *CMS50) makeCommand() []byte {
 	return []byte{0x7d, 0x81, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80}
 }
 
-func (c *CMS50) readBytes(n int) ([]byte, error) {
+func (c *CMS50) readBytes(ctx context.Context, n int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	buf := make([]byte, n)
 	read, err := c.device.Read(buf)
 	if err != nil && err != io.EOF {
@@ -66,13 +91,17 @@ func (c *CMS50) readBytes(n int) ([]byte, error) {
 	}
 
 	if read == 0 {
-		return nil, fmt.Errorf("Read 0 bytes from device. Is it turned on?")
+		return nil, ErrTimeout
 	}
 
 	return buf[:read], nil
 }
 
-func (c *CMS50) execCommand(command uint8) error {
+func (c *CMS50) execCommand(ctx context.Context, command uint8) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	cmd := c.makeCommand()
 	cmd[2] |= (command & 0x7f)
 
@@ -86,10 +115,16 @@ func (c *CMS50) execCommand(command uint8) error {
 	return nil
 }
 
-func (c *CMS50) execCommandWithArg(command, arg uint8) error {
+func (c *CMS50) execCommandWithArgs(ctx context.Context, command uint8, args ...uint8) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	cmd := c.makeCommand()
 	cmd[2] |= (command & 0x7f)
-	cmd[4] |= (arg & 0x7f)
+	for i, arg := range args {
+		cmd[4+i] |= (arg & 0x7f)
+	}
 
 	log.Debugf("Send Command With Arg: % x", cmd)
 
@@ -101,14 +136,32 @@ func (c *CMS50) execCommandWithArg(command, arg uint8) error {
 	return nil
 }
 
-func (c *CMS50) Connect(port string) error {
-	conf := &serial.Config{
-		Name:        port,
-		Baud:        115200,
-		ReadTimeout: time.Second * 5,
+func (c *CMS50) SetCapture(w io.Writer) {
+	c.capture = w
+}
+
+func (c *CMS50) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = &policy
+}
+
+// withRetry runs the idempotent command fn using the retry policy
+func (c *CMS50) withRetry(ctx context.Context, name string, fn func() error) error {
+	policy := DefaultRetryPolicy
+	if c.retry != nil {
+		policy = *c.retry
 	}
 
-	dev, err := serial.OpenPort(conf)
+	return policy.Do(ctx, name, func() error { return flush(c.device) }, fn)
+}
+
+func (c *CMS50) Connect(ctx context.Context, port string) error {
+	interval, err := portInterval(port, c.sampleInterval())
+	if err != nil {
+		return err
+	}
+	c.Interval = interval
+
+	dev, err := connect(ctx, port, TransportConfig{Baud: 115200, ReadTimeout: time.Second * 5}, c.capture)
 	if err != nil {
 		return err
 	}
@@ -117,201 +170,432 @@ func (c *CMS50) Connect(port string) error {
 	return nil
 }
 
-func (c *CMS50) ResetDevice() error {
-	err := c.execCommand(CommandHello1)
+func (c *CMS50) Close() error {
+	if closer, ok := c.device.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func (c *CMS50) ResetDevice(ctx context.Context) error {
+	return c.withRetry(ctx, "ResetDevice", func() error {
+		return c.resetDevice(ctx)
+	})
+}
+
+func (c *CMS50) resetDevice(ctx context.Context) error {
+	err := c.execCommand(ctx, CommandHello1)
 	if err != nil {
 		return err
 	}
 
-	res, err := c.readBytes(8)
+	res, err := c.readBytes(ctx, 8)
 	if err != nil {
 		return err
 	}
 
 	time.Sleep(100 * time.Millisecond)
 	if res[0] != 0xc {
-		return fmt.Errorf("Unknown result for CommandHello1: % x", res)
+		return &UnexpectedHeaderError{Command: "CommandHello1", Packet: res}
 	}
 
-	err = c.execCommand(CommandHello2)
+	err = c.execCommand(ctx, CommandHello2)
 	if err != nil {
 		return err
 	}
 
-	res, err = c.readBytes(8)
+	res, err = c.readBytes(ctx, 8)
 	if err != nil {
 		return err
 	}
 
 	time.Sleep(100 * time.Millisecond)
 	if res[0] != 0xc {
-		return fmt.Errorf("Unknown result for CommandHello2: % x", res)
+		return &UnexpectedHeaderError{Command: "CommandHello2", Packet: res}
 	}
 
 	return nil
 }
 
-func (c *CMS50) GetUser() (string, error) {
+func (c *CMS50) GetUser(ctx context.Context) (string, error) {
 	if len(c.user) > 0 {
 		return c.user, nil
 	}
 
-	err := c.execCommand(CommandGetUserInfo)
+	user, err := c.readString(ctx, CommandGetUserInfo, 0x05, "CommandGetUserInfo")
 	if err != nil {
 		return "", err
 	}
 
-	res, err := c.readBytes(100)
-	if err != nil {
-		return "", err
+	c.user = user
+
+	return c.user, nil
+}
+
+func (c *CMS50) GetModel(ctx context.Context) (string, error) {
+	if len(c.model) > 0 {
+		return c.model, nil
 	}
 
-	if res[0] != 0x05 {
-		return "", fmt.Errorf("Unknown result for CommandGetUserInfo: % x", res)
+	var res []byte
+	err := c.withRetry(ctx, "CommandGetOximeterModel", func() error {
+		err := c.execCommand(ctx, CommandGetOximeterModel)
+		if err != nil {
+			return err
+		}
+
+		res, err = c.readBytes(ctx, 100)
+		if err != nil {
+			return err
+		}
+
+		if res[0] != 0x02 {
+			return &UnexpectedHeaderError{Command: "CommandGetOximeterModel", Packet: res}
+		}
+
+		if len(res) < 8 {
+			return &ShortReadError{Command: "CommandGetOximeterModel", Want: 8, Got: len(res)}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
 
-	log.Debugf("Received %d bytes for user: % x", len(res), res)
+	log.Debugf("Received %d bytes for model string: % x", len(res), res)
 
 	for i := 3; i < len(res); i++ {
 		res[i] ^= 0x80
 	}
 
-	user := bytes.TrimRightFunc(res[3:], func(r rune) bool {
-		if r == 0x00 {
-			return true
+	c.model = strings.TrimSpace(string(res[3:8]))
+
+	return c.model, nil
+}
+
+// readString reads a null padded string sent in response to command
+func (c *CMS50) readString(ctx context.Context, command, header uint8, name string) (string, error) {
+	var res []byte
+	err := c.withRetry(ctx, name, func() error {
+		err := c.execCommand(ctx, command)
+		if err != nil {
+			return err
 		}
-		return false
+
+		res, err = c.readBytes(ctx, 100)
+		if err != nil {
+			return err
+		}
+
+		if res[0] != header {
+			return &UnexpectedHeaderError{Command: name, Packet: res}
+		}
+
+		if len(res) < 3 {
+			return &ShortReadError{Command: name, Want: 3, Got: len(res)}
+		}
+
+		return nil
 	})
+	if err != nil {
+		return "", err
+	}
 
-	c.user = strings.TrimSpace(string(user))
+	log.Debugf("Received %d bytes for %s: % x", len(res), name, res)
 
-	return c.user, nil
+	for i := 3; i < len(res); i++ {
+		res[i] ^= 0x80
+	}
+
+	str := bytes.TrimRightFunc(res[3:], func(r rune) bool {
+		return r == 0x00
+	})
+
+	return strings.TrimSpace(string(str)), nil
 }
 
-func (c *CMS50) GetModel() (string, error) {
-	if len(c.model) > 0 {
-		return c.model, nil
+func (c *CMS50) GetVendor(ctx context.Context) (string, error) {
+	if len(c.vendor) > 0 {
+		return c.vendor, nil
 	}
 
-	err := c.execCommand(CommandGetOximeterModel)
+	vendor, err := c.readString(ctx, CommandGetOximeterVendor, 0x03, "CommandGetOximeterVendor")
 	if err != nil {
 		return "", err
 	}
 
-	res, err := c.readBytes(100)
+	c.vendor = vendor
+
+	return c.vendor, nil
+}
+
+func (c *CMS50) GetDeviceID(ctx context.Context) (string, error) {
+	if len(c.deviceID) > 0 {
+		return c.deviceID, nil
+	}
+
+	deviceID, err := c.readString(ctx, CommandGetOximeterDeviceid, 0x04, "CommandGetOximeterDeviceid")
 	if err != nil {
 		return "", err
 	}
 
-	if res[0] != 0x02 {
-		return "", fmt.Errorf("Unknown result for CommandGetOximeterModel: % x", res)
-	}
+	c.deviceID = deviceID
 
-	log.Debugf("Received %d bytes for model string: % x", len(res), res)
+	return c.deviceID, nil
+}
 
-	for i := 3; i < len(res); i++ {
-		res[i] ^= 0x80
+func (c *CMS50) GetInfo(ctx context.Context) (string, error) {
+	if len(c.info) > 0 {
+		return c.info, nil
 	}
 
-	c.model = strings.TrimSpace(string(res[3:8]))
+	info, err := c.readString(ctx, CommandGetOximeterInfo, 0x06, "CommandGetOximeterInfo")
+	if err != nil {
+		return "", err
+	}
 
-	return c.model, nil
+	c.info = info
+
+	return c.info, nil
 }
 
-func (c *CMS50) GetSessionCount() (uint8, error) {
-	err := c.execCommand(CommandGetSessionCount)
+func (c *CMS50) GetIdentity(ctx context.Context) (*DeviceIdentity, error) {
+	vendor, err := c.GetVendor(ctx)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	res, err := c.readBytes(8)
+	model, err := c.GetModel(ctx)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	if res[0] != 0x0a {
-		return 0, fmt.Errorf("Unknown result for CommandGetSessionCount: % x", res)
+	deviceID, err := c.GetDeviceID(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Debugf("Received %d bytes for session count: % x", len(res), res)
+	info, err := c.GetInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	c.sessionCount = res[3] ^ 0x80
+	user, err := c.GetUser(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	return c.sessionCount, nil
+	return &DeviceIdentity{Vendor: vendor, Model: model, DeviceID: deviceID, Info: info, User: user}, nil
 }
 
-func (c *CMS50) GetSessionDuration(session uint8) (time.Duration, error) {
-	err := c.execCommandWithArg(CommandGetSessionDuration, session)
+func (c *CMS50) GetSessionCount(ctx context.Context) (uint8, error) {
+	var res []byte
+	err := c.withRetry(ctx, "CommandGetSessionCount", func() error {
+		err := c.execCommand(ctx, CommandGetSessionCount)
+		if err != nil {
+			return err
+		}
+
+		res, err = c.readBytes(ctx, 8)
+		if err != nil {
+			return err
+		}
+
+		if res[0] != 0x0a {
+			return &UnexpectedHeaderError{Command: "CommandGetSessionCount", Packet: res}
+		}
+
+		if len(res) < 4 {
+			return &ShortReadError{Command: "CommandGetSessionCount", Want: 4, Got: len(res)}
+		}
+
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	res, err := c.readBytes(8)
+	log.Debugf("Received %d bytes for session count: % x", len(res), res)
+
+	c.sessionCount = res[3] ^ 0x80
+
+	return c.sessionCount, nil
+}
+
+func (c *CMS50) GetSessionDuration(ctx context.Context, session uint8) (time.Duration, error) {
+	var res []byte
+	err := c.withRetry(ctx, "CommandGetSessionDuration", func() error {
+		err := c.execCommandWithArgs(ctx, CommandGetSessionDuration, session)
+		if err != nil {
+			return err
+		}
+
+		res, err = c.readBytes(ctx, 8)
+		if err != nil {
+			return err
+		}
+
+		if res[0] != 0x08 {
+			return &UnexpectedHeaderError{Command: "CommandGetSessionDuration", Packet: res}
+		}
+
+		if len(res) < 7 {
+			return &ShortReadError{Command: "CommandGetSessionDuration", Want: 7, Got: len(res)}
+		}
+
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	if res[0] != 0x08 {
-		return 0, fmt.Errorf("Unknown result for CommandGetSessionDuration: % x", res)
-	}
-
 	log.Debugf("Received %d bytes for session duration: % x", len(res), res)
 
-	if len(res) < 7 {
-		return 0, fmt.Errorf("Not enough bytes returned for CommandGetSessionDuration. Need 7 got %d", len(res))
-	}
-
 	for i := 1; i < 7; i++ {
 		res[i] ^= 0x80
 	}
 
-	seconds := (uint16(res[1]) & 0x4) << 5
-	seconds |= uint16(res[4])
-	seconds |= (uint16(res[5]) | ((uint16(res[1]) & 0x8) << 4)) << 8
-	seconds |= (uint16(res[6]) | ((uint16(res[1]) & 0x10) << 3)) << 16
+	seconds := (uint32(res[1]) & 0x4) << 5
+	seconds |= uint32(res[4])
+	seconds |= (uint32(res[5]) | ((uint32(res[1]) & 0x8) << 4)) << 8
+	seconds |= (uint32(res[6]) | ((uint32(res[1]) & 0x10) << 3)) << 16
 
 	log.Debugf("Session duration is %d / %d", seconds, DurationDivisor)
 
-	duration := time.Duration(seconds/DurationDivisor) * time.Second
+	// The device counts DurationDivisor for each stored sample
+	duration := time.Duration(seconds/DurationDivisor) * c.sampleInterval()
 
 	log.Debugf("Session %d has duration of %s (%.1fs)", session, duration, duration.Seconds())
 
 	return duration, nil
 }
 
-func (c *CMS50) GetSessionTime(session uint8) (time.Time, error) {
-	err := c.execCommandWithArg(CommandGetSessionTime, session)
+// GetSampleInterval returns how often samples are stored in the device
+// memory. The device does not report it so the Interval field is used for all
+// sessions.
+func (c *CMS50) GetSampleInterval(ctx context.Context, session uint8) (time.Duration, error) {
+	return c.sampleInterval(), nil
+}
+
+func (c *CMS50) sampleInterval() time.Duration {
+	if c.Interval <= 0 {
+		return CMS50DefaultInterval
+	}
+
+	return c.Interval
+}
+
+func (c *CMS50) GetSessionTime(ctx context.Context, session uint8) (time.Time, error) {
+	var dateTime time.Time
+	err := c.withRetry(ctx, "CommandGetSessionTime", func() error {
+		err := c.execCommandWithArgs(ctx, CommandGetSessionTime, session)
+		if err != nil {
+			return err
+		}
+
+		dateTime, err = c.readDateTime(ctx, "CommandGetSessionTime")
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	log.Debugf("Session %d start time is: %s", session, dateTime)
+
+	return dateTime, nil
+}
+
+// GetTime returns the current time of the device clock
+func (c *CMS50) GetTime(ctx context.Context) (time.Time, error) {
+	var dateTime time.Time
+	err := c.withRetry(ctx, "CommandGetDateTime", func() error {
+		err := c.execCommand(ctx, CommandGetDateTime)
+		if err != nil {
+			return err
+		}
+
+		dateTime, err = c.readDateTime(ctx, "CommandGetDateTime")
+		return err
+	})
 	if err != nil {
 		return time.Time{}, err
 	}
 
-	dateRes, err := c.readBytes(8)
+	log.Debugf("Device time is: %s", dateTime)
+
+	return dateTime, nil
+}
+
+// SetTime sets the device clock. The device has no notion of time zones so
+// the time is set in the local time zone.
+func (c *CMS50) SetTime(ctx context.Context, t time.Time) error {
+	t = t.In(time.Local)
+
+	err := c.execCommandWithArgs(ctx, CommandSetDate, uint8(t.Year()/100), uint8(t.Year()%100), uint8(t.Month()), uint8(t.Day()))
+	if err != nil {
+		return err
+	}
+
+	res, err := c.readBytes(ctx, 8)
+	if err != nil {
+		return err
+	}
+
+	if res[0] != 0x0c {
+		return &UnexpectedHeaderError{Command: "CommandSetDate", Packet: res}
+	}
+
+	err = c.execCommandWithArgs(ctx, CommandSetTime, uint8(t.Hour()), uint8(t.Minute()), uint8(t.Second()))
+	if err != nil {
+		return err
+	}
+
+	res, err = c.readBytes(ctx, 8)
+	if err != nil {
+		return err
+	}
+
+	if res[0] != 0x0c {
+		return &UnexpectedHeaderError{Command: "CommandSetTime", Packet: res}
+	}
+
+	log.Debugf("Set device time to: %s", t)
+
+	return nil
+}
+
+// readDateTime reads the date and time packets the device sends in response to
+// the command name
+func (c *CMS50) readDateTime(ctx context.Context, name string) (time.Time, error) {
+	dateRes, err := c.readBytes(ctx, 8)
 	if err != nil {
 		return time.Time{}, err
 	}
 
-	timeRes, err := c.readBytes(8)
+	timeRes, err := c.readBytes(ctx, 8)
 	if err != nil {
 		return time.Time{}, err
 	}
 
 	if len(dateRes) != 8 {
-		return time.Time{}, fmt.Errorf("Not enough bytes returned for date CommandGetSessionTime. Need 8 got %d", len(dateRes))
+		return time.Time{}, &ShortReadError{Command: "date " + name, Want: 8, Got: len(dateRes)}
 	}
 
 	if len(timeRes) != 8 {
-		return time.Time{}, fmt.Errorf("Not enough bytes returned for time CommandGetSessionTime. Need 8 got %d", len(timeRes))
+		return time.Time{}, &ShortReadError{Command: "time " + name, Want: 8, Got: len(timeRes)}
 	}
 
 	if dateRes[0] != 0x07 {
-		return time.Time{}, fmt.Errorf("Unknown result for date CommandGetSessionTime: % x", dateRes)
+		return time.Time{}, &UnexpectedHeaderError{Command: "date " + name, Packet: dateRes}
 	}
 	if timeRes[0] != 0x12 {
-		return time.Time{}, fmt.Errorf("Unknown result for time CommandGetSessionTime: % x", timeRes)
+		return time.Time{}, &UnexpectedHeaderError{Command: "time " + name, Packet: timeRes}
 	}
 
-	log.Debugf("Received %d bytes for session date: % x", len(dateRes), dateRes)
-	log.Debugf("Received %d bytes for session time: % x", len(timeRes), timeRes)
+	log.Debugf("Received %d bytes for date: % x", len(dateRes), dateRes)
+	log.Debugf("Received %d bytes for time: % x", len(timeRes), timeRes)
 
 	date := make([]int, 8)
 	tim := make([]int, 8)
@@ -321,68 +605,216 @@ func (c *CMS50) GetSessionTime(session uint8) (time.Time, error) {
 		tim[i] = int(timeRes[i]) & ^0x80
 	}
 
-	year, _ := strconv.Atoi(fmt.Sprintf("%d%d", date[4], date[5]))
+	year := date[4]*100 + date[5]
 
-	dateTime := time.Date(year, time.Month(date[6]), date[7], tim[4], tim[5], tim[6], tim[7], time.Local)
+	return time.Date(year, time.Month(date[6]), date[7], tim[4], tim[5], tim[6], tim[7], time.Local), nil
+}
 
-	log.Debugf("Session %d start time is: %s", session, dateTime)
+func (c *CMS50) GetSessionData(ctx context.Context, session uint8) ([]*model.OxiRecord, error) {
+	data := make([]*model.OxiRecord, 0)
+	err := c.StreamSessionData(ctx, session, collectRecords(&data))
+	if err != nil {
+		return nil, err
+	}
 
-	return dateTime, nil
+	return data, nil
 }
 
-func (c *CMS50) GetSessionData(session uint8) ([]*model.OxiRecord, error) {
-	err := c.ResetDevice()
+// StreamSessionData downloads a session from the device memory passing each
+// record to handler as the packets are decoded
+func (c *CMS50) StreamSessionData(ctx context.Context, session uint8, handler RecordHandler) error {
+	err := c.ResetDevice(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = c.execCommandWithArg(CommandGetSessionData, session)
+	err = c.execCommandWithArgs(ctx, CommandGetSessionData, session)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	reader := bufio.NewReader(c.device)
-	data := make([]*model.OxiRecord, 0)
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		buf := make([]byte, 8)
 
-		_, err := io.ReadFull(reader, buf)
+		n, err := io.ReadFull(reader, buf)
 		if err == io.EOF {
 			break
+		} else if err == io.ErrUnexpectedEOF {
+			return &ShortReadError{Command: "CommandGetSessionData", Want: len(buf), Got: n}
 		} else if err != nil {
-			return nil, err
+			return err
 		}
 
 		if buf[0] != 0x0f {
-			return nil, fmt.Errorf("Unknown result for CommandGetSessionData: % x", buf)
+			return &UnexpectedHeaderError{Command: "CommandGetSessionData", Packet: buf}
 		}
 
-		data = append(data, c.newOxiRecords(buf)...)
+		for _, rec := range c.newOxiRecords(buf) {
+			err := handler(rec)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	return data, nil
+	return nil
 }
 
-func (c *CMS50) newOxiRecord(pulse, spo2 uint8) *model.OxiRecord {
-	if pulse == 0xff {
-		return &model.OxiRecord{Pulse: 0, Spo2: 0}
+// EraseSessions clears all sessions stored in the device memory
+func (c *CMS50) EraseSessions(ctx context.Context) error {
+	err := c.execCommand(ctx, CommandSessionErase)
+	if err != nil {
+		return err
 	}
 
-	return &model.OxiRecord{Pulse: pulse, Spo2: spo2}
+	res, err := c.readBytes(ctx, 8)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Received %d bytes for session erase: % x", len(res), res)
+
+	count, err := c.GetSessionCount(ctx)
+	if err != nil {
+		return err
+	}
+
+	if count != 0 {
+		return fmt.Errorf("Device still has %d sessions after CommandSessionErase", count)
+	}
+
+	return nil
 }
-func (c *CMS50) newOxiRecords(buf []byte) []*model.OxiRecord {
-	data := make([]*model.OxiRecord, 3)
+
+// StreamLiveData streams real-time data from the device until ctx is done.
+// One record per second is passed to handler. If waveform is not nil it is
+// passed every plethysmograph sample (LiveDataRate per second) received while
+// a finger is in the device.
+func (c *CMS50) StreamLiveData(ctx context.Context, handler RecordHandler, waveform WaveformHandler) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	err := c.execCommand(ctx, CommandLiveDataStart)
+	if err != nil {
+		return err
+	}
+	// Always stop the stream, even after ctx is cancelled
+	defer c.execCommand(context.Background(), CommandLiveDataStop)
+
+	reader := bufio.NewReader(c.device)
+	start := time.Now()
+	lastKeepAlive := start
+	packets := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debugf("Stopping live data stream after %d packets", packets)
+			return nil
+		default:
+		}
+
+		if time.Since(lastKeepAlive) >= KeepAliveInterval {
+			err := c.execCommand(ctx, CommandKeepAlive)
+			if err != nil {
+				return err
+			}
+			lastKeepAlive = time.Now()
+		}
+
+		buf, err := c.readLivePacket(reader)
+		if err == io.EOF {
+			log.Warn("Device stopped sending live data")
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		c.unpackHighBits(buf)
+		log.Debugf("Got new live data packet: % x", buf)
+
+		if waveform != nil && buf[5] != 0xff {
+			sample := &model.WaveformSample{
+				DateTime: start.Add(time.Second * time.Duration(packets) / LiveDataRate),
+				Pleth:    buf[3],
+			}
+			err := waveform(sample)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The device sends LiveDataRate packets per second. Only pass along
+		// one record per second.
+		if packets%LiveDataRate == 0 {
+			rec := c.newOxiRecord(buf[5], buf[6])
+			rec.DateTime = start.Add(time.Second * time.Duration(packets/LiveDataRate))
+			err := handler(rec)
+			if err != nil {
+				return err
+			}
+		}
+
+		packets++
+	}
+}
+
+func (c *CMS50) readLivePacket(reader *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip anything that isn't the start of a live data packet. Payload
+		// bytes always have the high bit set so we can resync on the header.
+		if b != 0x01 {
+			log.Debugf("Skipping unexpected byte in live data stream: %x", b)
+			continue
+		}
+
+		buf := make([]byte, 9)
+		buf[0] = b
+		_, err = io.ReadFull(reader, buf[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return buf, nil
+	}
+}
+
+// unpackHighBits restores the high bit of each payload byte from the bit
+// flags sent in the second byte of the packet
+func (c *CMS50) unpackHighBits(buf []byte) {
 	msb := buf[1]
 	for i := 2; i < len(buf); i++ {
 		buf[i] &= 0x7f
 		if msb&0x01 != 0 {
 			buf[i] |= 0x80
-		} else {
-			buf[i] |= 0
 		}
 		msb >>= 1
 	}
+}
+
+func (c *CMS50) newOxiRecord(pulse, spo2 uint8) *model.OxiRecord {
+	if pulse == 0xff {
+		return &model.OxiRecord{Status: model.StatusFingerOut}
+	}
+
+	return &model.OxiRecord{Pulse: pulse, Spo2: spo2}
+}
+
+func (c *CMS50) newOxiRecords(buf []byte) []*model.OxiRecord {
+	data := make([]*model.OxiRecord, 3)
+	c.unpackHighBits(buf)
 
 	data[0] = c.newOxiRecord(buf[3], buf[2])
 	data[1] = c.newOxiRecord(buf[5], buf[4])
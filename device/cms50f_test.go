@@ -18,16 +18,22 @@
 package device
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"testing"
 	"time"
 
+	"github.com/aebruno/myoxi/model"
 	log "github.com/sirupsen/logrus"
 )
 
 type MockCMS50 struct {
 	command uint8
 	counter int
+	erased  bool
+	written map[uint8][]byte
 }
 
 func (c *MockCMS50) Read(p []byte) (int, error) {
@@ -39,13 +45,24 @@ func (c *MockCMS50) Read(p []byte) (int, error) {
 		res = []byte{0xc, 0x80}
 	case CommandGetUserInfo:
 		res = []byte{0x05, 0x80, 0x80, 0xf5, 0xf3, 0xe5, 0xf2, 0x80, 0x80}
+	case CommandGetOximeterVendor:
+		res = []byte{0x03, 0x80, 0x80, 0xc3, 0xcf, 0xce, 0xd4, 0xc5, 0xc3, 0x80, 0x80}
+	case CommandGetOximeterDeviceid:
+		res = []byte{0x04, 0x80, 0x80, 0xb0, 0xb1, 0xb5, 0xb4, 0xb3, 0xb2, 0xb1, 0x80}
+	case CommandGetOximeterInfo:
+		res = []byte{0x06, 0x80, 0x80, 0xd6, 0xb1, 0xae, 0xb2, 0x80, 0x80, 0x80, 0x80}
 	case CommandGetOximeterModel:
 		res = []byte{0x02, 0x80, 0x80, 0xb5, 0xb0, 0xc6, 0xa0, 0xa0, 0xa0, 0x02, 0x81, 0xff, 0xa0, 0x80, 0x80, 0x80, 0x80, 0x80}
 	case CommandGetSessionCount:
 		res = []byte{0x0a, 0x80, 0x80, 0x81}
+		if c.erased {
+			res[3] = 0x80
+		}
+	case CommandSessionErase, CommandSetDate, CommandSetTime:
+		res = []byte{0x0c, 0x80}
 	case CommandGetSessionDuration:
 		res = []byte{0x08, 0x88, 0x80, 0x80, 0xfc, 0xca, 0x80, 0x80}
-	case CommandGetSessionTime:
+	case CommandGetSessionTime, CommandGetDateTime:
 		if c.counter == 0 {
 			res = []byte{0x07, 0x80, 0x80, 0x80, 0x94, 0x92, 0x8b, 0x92}
 		} else {
@@ -59,6 +76,8 @@ func (c *MockCMS50) Read(p []byte) (int, error) {
 			0x0f, 0x80, 0x92, 0x80, 0x8b, 0xa7, 0xe1, 0xc4,
 			0x0f, 0x80, 0xe1, 0xc5, 0xe0, 0xc5, 0xe0, 0xc5,
 		}
+	case CommandLiveDataStart:
+		res = mockLiveData()
 	}
 
 	copy(p, res)
@@ -67,9 +86,37 @@ func (c *MockCMS50) Read(p []byte) (int, error) {
 	return len(res), io.EOF
 }
 
+// mockLiveData returns a little over two seconds of live data packets. The
+// pulse and spo2 change each second and the third second is finger out.
+func mockLiveData() []byte {
+	// Junk bytes that should be skipped while syncing to the first packet
+	data := []byte{0x80, 0xff}
+	for i := 0; i < LiveDataRate*2+10; i++ {
+		second := i / LiveDataRate
+		msb := uint8(0x80)
+		pulse := uint8(60 + second)
+		spo2 := uint8(98 - second)
+		if second == 2 {
+			msb |= 0x08
+			pulse = 0xff
+			spo2 = 0x7f
+		}
+		data = append(data, 0x01, msb, 0x80, 0x80|uint8(i%100), 0x80, 0x80|(pulse&0x7f), 0x80|spo2, 0x80, 0x80)
+	}
+
+	return data
+}
+
 func (c *MockCMS50) Write(p []byte) (int, error) {
 	c.counter = 0
 	c.command = p[2]
+	if c.command == CommandSessionErase {
+		c.erased = true
+	}
+	if c.written == nil {
+		c.written = make(map[uint8][]byte)
+	}
+	c.written[c.command] = append([]byte{}, p...)
 	return len(p), nil
 }
 
@@ -86,7 +133,7 @@ func newTestDevice() *CMS50 {
 
 func TestReset(t *testing.T) {
 	cms := newTestDevice()
-	err := cms.ResetDevice()
+	err := cms.ResetDevice(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -94,7 +141,7 @@ func TestReset(t *testing.T) {
 
 func TestGetUser(t *testing.T) {
 	cms := newTestDevice()
-	user, err := cms.GetUser()
+	user, err := cms.GetUser(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -106,7 +153,7 @@ func TestGetUser(t *testing.T) {
 
 func TestGetModel(t *testing.T) {
 	cms := newTestDevice()
-	model, err := cms.GetModel()
+	model, err := cms.GetModel(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -116,9 +163,23 @@ func TestGetModel(t *testing.T) {
 	}
 }
 
+func TestGetIdentity(t *testing.T) {
+	cms := newTestDevice()
+	identity, err := cms.GetIdentity(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := DeviceIdentity{Vendor: "CONTEC", Model: "50F", DeviceID: "0154321", Info: "V1.2", User: "user"}
+
+	if *identity != valid {
+		t.Errorf("Invalid identity: got '%+v' should be '%+v'", *identity, valid)
+	}
+}
+
 func TestGetSessionCount(t *testing.T) {
 	cms := newTestDevice()
-	count, err := cms.GetSessionCount()
+	count, err := cms.GetSessionCount(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -130,7 +191,7 @@ func TestGetSessionCount(t *testing.T) {
 
 func TestGetSessionDuration(t *testing.T) {
 	cms := newTestDevice()
-	duration, err := cms.GetSessionDuration(1)
+	duration, err := cms.GetSessionDuration(context.Background(), 1)
 	if err != nil {
 		t.Error(err)
 	}
@@ -144,7 +205,7 @@ func TestGetSessionDuration(t *testing.T) {
 
 func TestGetSessionTime(t *testing.T) {
 	cms := newTestDevice()
-	dateTime, err := cms.GetSessionTime(1)
+	dateTime, err := cms.GetSessionTime(context.Background(), 1)
 	if err != nil {
 		t.Error(err)
 	}
@@ -156,9 +217,60 @@ func TestGetSessionTime(t *testing.T) {
 	}
 }
 
+func TestGetTime(t *testing.T) {
+	cms := newTestDevice()
+	dateTime, err := cms.GetTime(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	validDateTime := time.Date(2018, time.November, 18, 0, 11, 39, 0, time.Local)
+
+	if dateTime != validDateTime {
+		t.Errorf("Invalid device time: got '%s' should be '%s'", dateTime, validDateTime)
+	}
+}
+
+func TestSetTime(t *testing.T) {
+	cms := newTestDevice()
+	err := cms.SetTime(context.Background(), time.Date(2021, time.March, 4, 5, 6, 7, 0, time.Local))
+	if err != nil {
+		t.Error(err)
+	}
+
+	mock := cms.device.(*MockCMS50)
+
+	validDate := []byte{0x7d, 0x81, 0xb3, 0x80, 0x94, 0x95, 0x83, 0x84, 0x80}
+	if !bytes.Equal(mock.written[CommandSetDate], validDate) {
+		t.Errorf("Invalid set date command: got '% x' should be '% x'", mock.written[CommandSetDate], validDate)
+	}
+
+	validTime := []byte{0x7d, 0x81, 0xb2, 0x80, 0x85, 0x86, 0x87, 0x80, 0x80}
+	if !bytes.Equal(mock.written[CommandSetTime], validTime) {
+		t.Errorf("Invalid set time command: got '% x' should be '% x'", mock.written[CommandSetTime], validTime)
+	}
+}
+
+func TestEraseSessions(t *testing.T) {
+	cms := newTestDevice()
+	err := cms.EraseSessions(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	count, err := cms.GetSessionCount(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Invalid session count after erase: got '%d' should be '%d'", count, 0)
+	}
+}
+
 func TestGetSessionData(t *testing.T) {
 	cms := newTestDevice()
-	data, err := cms.GetSessionData(0)
+	data, err := cms.GetSessionData(context.Background(), 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -167,3 +279,121 @@ func TestGetSessionData(t *testing.T) {
 		t.Errorf("Invalid session data: got '%d' should be '%d'", len(data), 3)
 	}
 }
+
+func TestStreamSessionData(t *testing.T) {
+	cms := newTestDevice()
+
+	count := 0
+	err := cms.StreamSessionData(context.Background(), 0, func(rec *model.OxiRecord) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if count != 15 {
+		t.Errorf("Invalid session data: got '%d' records should be '%d'", count, 15)
+	}
+
+	// Handler errors stop the download
+	stop := errors.New("stop")
+	count = 0
+	err = cms.StreamSessionData(context.Background(), 0, func(rec *model.OxiRecord) error {
+		count++
+		if count == 4 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Invalid error: got '%v' should be '%v'", err, stop)
+	}
+
+	if count != 4 {
+		t.Errorf("Invalid session data: got '%d' records should be '%d'", count, 4)
+	}
+}
+
+func TestStreamLiveData(t *testing.T) {
+	cms := newTestDevice()
+
+	data := make([]*model.OxiRecord, 0)
+	err := cms.StreamLiveData(context.Background(), func(rec *model.OxiRecord) error {
+		data = append(data, rec)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(data) != 3 {
+		t.Fatalf("Invalid live data: got '%d' records should be '%d'", len(data), 3)
+	}
+
+	valid := []*model.OxiRecord{
+		&model.OxiRecord{Pulse: 60, Spo2: 98},
+		&model.OxiRecord{Pulse: 61, Spo2: 97},
+		&model.OxiRecord{Status: model.StatusFingerOut},
+	}
+
+	for i := range valid {
+		if data[i].Pulse != valid[i].Pulse || data[i].Spo2 != valid[i].Spo2 || data[i].Status != valid[i].Status {
+			t.Errorf("Invalid live record %d: got '%s' should be '%s'", i, data[i], valid[i])
+		}
+		if i > 0 && data[i].DateTime.Sub(data[i-1].DateTime) != time.Second {
+			t.Errorf("Invalid live record %d: records should be one second apart", i)
+		}
+	}
+}
+
+func TestStreamLiveDataStop(t *testing.T) {
+	cms := newTestDevice()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	count := 0
+	err := cms.StreamLiveData(ctx, func(rec *model.OxiRecord) error {
+		count++
+		return nil
+	}, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Invalid live data: got '%d' records after stop should be '%d'", count, 0)
+	}
+}
+
+func TestStreamLiveDataWaveform(t *testing.T) {
+	cms := newTestDevice()
+
+	samples := make([]*model.WaveformSample, 0)
+	err := cms.StreamLiveData(context.Background(), func(rec *model.OxiRecord) error {
+		return nil
+	}, func(sample *model.WaveformSample) error {
+		samples = append(samples, sample)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Finger out packets in the third second should be skipped
+	if len(samples) != LiveDataRate*2 {
+		t.Fatalf("Invalid waveform data: got '%d' samples should be '%d'", len(samples), LiveDataRate*2)
+	}
+
+	for i, sample := range samples {
+		if sample.Pleth != uint8(i%100) {
+			t.Errorf("Invalid waveform sample %d: got '%d' should be '%d'", i, sample.Pleth, i%100)
+		}
+	}
+
+	step := samples[1].DateTime.Sub(samples[0].DateTime)
+	if step != time.Second/LiveDataRate {
+		t.Errorf("Invalid waveform sample interval: got '%s' should be '%s'", step, time.Second/LiveDataRate)
+	}
+}
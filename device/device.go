@@ -18,18 +18,53 @@
 package device
 
 import (
+	"context"
 	"time"
 
 	"github.com/aebruno/myoxi/model"
 )
 
+// RecordHandler is called for each record received from a device
+type RecordHandler func(rec *model.OxiRecord) error
+
+// collectRecords returns a RecordHandler that appends each record to data
+func collectRecords(data *[]*model.OxiRecord) RecordHandler {
+	return func(rec *model.OxiRecord) error {
+		*data = append(*data, rec)
+		return nil
+	}
+}
+
+// WaveformHandler is called for each plethysmograph sample received from a
+// device
+type WaveformHandler func(sample *model.WaveformSample) error
+
+// DeviceIdentity identifies the physical device a session was recorded on
+type DeviceIdentity struct {
+	Vendor   string
+	Model    string
+	DeviceID string
+	Info     string
+	User     string
+}
+
+// Device is an oximeter. All methods check ctx between reads from the device
+// so a blocked call returns at most one read timeout after ctx is cancelled.
 type Device interface {
-	Connect(port string) error
-	ResetDevice() error
-	GetModel() (string, error)
-	GetSessionCount() (uint8, error)
-	GetSessionDuration(session uint8) (time.Duration, error)
-	GetSessionTime(session uint8) (time.Time, error)
-	GetSessionData(session uint8) ([]*model.OxiRecord, error)
-	GetUser() (string, error)
+	Connect(ctx context.Context, port string) error
+	Close() error
+	ResetDevice(ctx context.Context) error
+	GetModel(ctx context.Context) (string, error)
+	GetIdentity(ctx context.Context) (*DeviceIdentity, error)
+	GetSessionCount(ctx context.Context) (uint8, error)
+	GetSessionDuration(ctx context.Context, session uint8) (time.Duration, error)
+	GetSessionTime(ctx context.Context, session uint8) (time.Time, error)
+	GetSampleInterval(ctx context.Context, session uint8) (time.Duration, error)
+	GetSessionData(ctx context.Context, session uint8) ([]*model.OxiRecord, error)
+	StreamSessionData(ctx context.Context, session uint8, handler RecordHandler) error
+	GetUser(ctx context.Context) (string, error)
+	GetTime(ctx context.Context) (time.Time, error)
+	SetTime(ctx context.Context, t time.Time) error
+	EraseSessions(ctx context.Context) error
+	StreamLiveData(ctx context.Context, handler RecordHandler, waveform WaveformHandler) error
 }
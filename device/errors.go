@@ -0,0 +1,121 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNotSupported is returned by devices that don't support an operation
+	ErrNotSupported = errors.New("Operation not supported by device")
+
+	// ErrTimeout is returned when the device doesn't answer before the read
+	// timeout. Usually the device is turned off or not connected.
+	ErrTimeout = errors.New("Timed out waiting for device. Is it turned on?")
+)
+
+// UnexpectedHeaderError is returned when the device answers a command with
+// a packet of the wrong type. Usually the device speaks a different protocol
+// or bytes were dropped.
+type UnexpectedHeaderError struct {
+	Command string
+	Packet  []byte
+}
+
+func (e *UnexpectedHeaderError) Error() string {
+	return fmt.Sprintf("Unknown result for %s: % x", e.Command, e.Packet)
+}
+
+// ShortReadError is returned when the device sends fewer bytes than a
+// packet needs
+type ShortReadError struct {
+	Command string
+	Want    int
+	Got     int
+}
+
+func (e *ShortReadError) Error() string {
+	return fmt.Sprintf("Not enough bytes returned for %s. Need %d got %d", e.Command, e.Want, e.Got)
+}
+
+// IsTemporary returns true if err may succeed when the command is retried
+func IsTemporary(err error) bool {
+	var headerErr *UnexpectedHeaderError
+	var shortErr *ShortReadError
+
+	return errors.Is(err, ErrTimeout) || errors.As(err, &headerErr) || errors.As(err, &shortErr)
+}
+
+// RetryPolicy controls how idempotent device commands are retried
+type RetryPolicy struct {
+	// Total number of attempts including the first
+	Attempts int
+
+	// Wait between attempts
+	Delay time.Duration
+}
+
+// DefaultRetryPolicy is used by drivers unless SetRetryPolicy is called
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Delay: 250 * time.Millisecond}
+
+// Retrier is implemented by drivers that retry idempotent commands
+type Retrier interface {
+	SetRetryPolicy(policy RetryPolicy)
+}
+
+// Do calls fn until it succeeds, returns an error that isn't temporary, the
+// attempts run out or ctx is done. A timeout on the first attempt is not
+// retried as the device is most likely turned off. If flush is not nil it is
+// called before each retry to discard late replies to the failed attempt.
+func (p RetryPolicy) Do(ctx context.Context, name string, flush func() error, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !IsTemporary(err) || attempt >= p.Attempts {
+			return err
+		}
+
+		if attempt == 1 && errors.Is(err, ErrTimeout) {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"error":   err,
+			"attempt": attempt,
+		}).Warnf("%s failed. Retrying", name)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.Delay):
+		}
+
+		if flush != nil {
+			ferr := flush()
+			if ferr != nil {
+				log.Debugf("Failed to flush device input: %s", ferr)
+			}
+		}
+	}
+}
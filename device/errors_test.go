@@ -0,0 +1,163 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// flakyCMS50 answers the first failures commands sent to a MockCMS50 with a
+// garbled packet that keeps arriving until the input is flushed. The first
+// timeouts commands are not answered.
+type flakyCMS50 struct {
+	MockCMS50
+	failures int
+	timeouts int
+	stale    bool
+	writes   int
+	flushes  int
+}
+
+func (c *flakyCMS50) Write(p []byte) (int, error) {
+	c.writes++
+	return c.MockCMS50.Write(p)
+}
+
+func (c *flakyCMS50) Read(p []byte) (int, error) {
+	if c.timeouts > 0 {
+		c.timeouts--
+		return 0, nil
+	}
+
+	if c.failures > 0 {
+		c.failures--
+		c.stale = true
+	}
+
+	if c.stale {
+		return copy(p, []byte{0x01, 0x80, 0x80, 0x80}), nil
+	}
+
+	return c.MockCMS50.Read(p)
+}
+
+func (c *flakyCMS50) Flush() error {
+	c.flushes++
+	c.stale = false
+	return nil
+}
+
+// wrongHeaderCMS50 answers every command with a live data packet
+type wrongHeaderCMS50 struct {
+	deadPort
+}
+
+func (c *wrongHeaderCMS50) Read(p []byte) (int, error) {
+	return copy(p, []byte{0x01, 0x80, 0x80, 0x80}), nil
+}
+
+func TestRetry(t *testing.T) {
+	flaky := &flakyCMS50{failures: 2}
+	cms := &CMS50{device: flaky}
+	cms.SetRetryPolicy(RetryPolicy{Attempts: 3})
+
+	count, err := cms.GetSessionCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("Invalid session count: got '%d' should be '%d'", count, 1)
+	}
+
+	if flaky.writes != 3 {
+		t.Errorf("Invalid number of attempts: got '%d' should be '%d'", flaky.writes, 3)
+	}
+
+	if flaky.flushes != 2 {
+		t.Errorf("Invalid number of flushes: got '%d' should be '%d'", flaky.flushes, 2)
+	}
+
+	flaky = &flakyCMS50{failures: 3}
+	cms = &CMS50{device: flaky}
+	cms.SetRetryPolicy(RetryPolicy{Attempts: 3})
+
+	_, err = cms.GetSessionCount(context.Background())
+	var headerErr *UnexpectedHeaderError
+	if !errors.As(err, &headerErr) {
+		t.Errorf("Invalid error: got '%v' should be UnexpectedHeaderError", err)
+	}
+
+	// A device that doesn't answer at all is not retried
+	flaky = &flakyCMS50{timeouts: 3}
+	cms = &CMS50{device: flaky}
+	cms.SetRetryPolicy(RetryPolicy{Attempts: 3})
+
+	_, err = cms.GetSessionCount(context.Background())
+	if err != ErrTimeout {
+		t.Errorf("Invalid error: got '%v' should be '%v'", err, ErrTimeout)
+	}
+
+	if flaky.writes != 1 {
+		t.Errorf("Invalid number of attempts: got '%d' should be '%d'", flaky.writes, 1)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	cms := &CMS50{device: &deadPort{}}
+	cms.SetRetryPolicy(RetryPolicy{Attempts: 1})
+
+	_, err := cms.GetModel(context.Background())
+	if err != ErrTimeout {
+		t.Errorf("Invalid error: got '%v' should be '%v'", err, ErrTimeout)
+	}
+
+	cms = &CMS50{device: &wrongHeaderCMS50{}}
+	cms.SetRetryPolicy(RetryPolicy{Attempts: 1})
+
+	_, err = cms.GetSessionDuration(context.Background(), 0)
+	var headerErr *UnexpectedHeaderError
+	if !errors.As(err, &headerErr) {
+		t.Fatalf("Invalid error: got '%v' should be UnexpectedHeaderError", err)
+	}
+
+	if headerErr.Command != "CommandGetSessionDuration" {
+		t.Errorf("Invalid command: got '%s' should be '%s'", headerErr.Command, "CommandGetSessionDuration")
+	}
+
+	if !IsTemporary(err) || !IsTemporary(&ShortReadError{}) || IsTemporary(ErrNotSupported) {
+		t.Errorf("Invalid temporary errors")
+	}
+
+	// Cancelled requests are not retried
+	flaky := &flakyCMS50{}
+	cms = &CMS50{device: flaky}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = cms.GetSessionCount(ctx)
+	if err != context.Canceled {
+		t.Errorf("Invalid error: got '%v' should be '%v'", err, context.Canceled)
+	}
+
+	if flaky.writes != 0 {
+		t.Errorf("Invalid number of attempts: got '%d' should be '%d'", flaky.writes, 0)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"testing"
+)
+
+// unregister removes a driver registered by a test so it does not show up in
+// later tests
+func unregister(name string) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	delete(drivers, name)
+}
+
+func TestRegistry(t *testing.T) {
+	dev, err := New("cms50f")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := dev.(*CMS50); !ok {
+		t.Errorf("Invalid device for driver cms50f: got '%T' should be '%T'", dev, &CMS50{})
+	}
+
+	_, err = New("unknown")
+	if err == nil {
+		t.Errorf("Expected error creating device with unknown driver")
+	}
+
+	Register("test-registry", func() Device { return &CMS50{} })
+	defer unregister("test-registry")
+
+	found := false
+	for _, name := range Drivers() {
+		if name == "test-registry" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("Registered driver missing from list of drivers: %v", Drivers())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic registering the same driver twice")
+		}
+	}()
+
+	Register("test-registry", func() Device { return &CMS50{} })
+}
+
+func TestRegistryCleanup(t *testing.T) {
+	for _, name := range Drivers() {
+		if name == "test-registry" || name == "test-scan" {
+			t.Errorf("Test driver left in list of drivers: %v", Drivers())
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// Candidate serial ports for USB connected oximeters. Persistent names
+	// under /dev/serial/by-id are listed first.
+	PortGlobs = []string{
+		"/dev/serial/by-id/*",
+		"/dev/ttyUSB*",
+		"/dev/ttyACM*",
+		"/dev/cu.usbserial*",
+		"/dev/cu.SLAB_USBtoUART*",
+	}
+
+	// Path to the sysfs tty class used to look up USB vendor and product IDs
+	SysfsTTY = "/sys/class/tty"
+)
+
+// PortInfo describes a candidate serial port
+type PortInfo struct {
+	// Path to the device file
+	Path string
+
+	// Persistent /dev/serial/by-id link to the device file, if any
+	Link string
+
+	// USB vendor ID, product ID and product name, if available
+	VID     string
+	PID     string
+	Product string
+}
+
+func (p *PortInfo) String() string {
+	str := p.Path
+	if len(p.Link) > 0 {
+		str += " (" + p.Link + ")"
+	}
+	if len(p.VID) > 0 {
+		str += fmt.Sprintf(" [%s:%s]", p.VID, p.PID)
+	}
+	if len(p.Product) > 0 {
+		str += " " + p.Product
+	}
+
+	return str
+}
+
+// ListPorts returns the candidate serial ports an oximeter may be connected to
+func ListPorts() ([]*PortInfo, error) {
+	return listPorts(PortGlobs, SysfsTTY)
+}
+
+func listPorts(globs []string, sysfs string) ([]*PortInfo, error) {
+	ports := make([]*PortInfo, 0)
+	seen := make(map[string]*PortInfo)
+
+	for _, pattern := range globs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			path, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				log.Debugf("Skipping port %s: %s", match, err)
+				continue
+			}
+
+			if _, ok := seen[path]; ok {
+				continue
+			}
+
+			port := &PortInfo{Path: path}
+			if path != match {
+				port.Link = match
+			}
+
+			port.VID, port.PID, port.Product = usbInfo(sysfs, filepath.Base(path))
+
+			seen[path] = port
+			ports = append(ports, port)
+		}
+	}
+
+	return ports, nil
+}
+
+// usbInfo returns the USB vendor ID, product ID and product name for the tty
+// by walking up the sysfs device tree to the USB device
+func usbInfo(sysfs, name string) (string, string, string) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysfs, name, "device"))
+	if err != nil {
+		return "", "", ""
+	}
+
+	for i := 0; i < 5; i++ {
+		vid := readSysfsFile(filepath.Join(dir, "idVendor"))
+		if len(vid) > 0 {
+			pid := readSysfsFile(filepath.Join(dir, "idProduct"))
+			product := readSysfsFile(filepath.Join(dir, "product"))
+			return vid, pid, product
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	return "", "", ""
+}
+
+func readSysfsFile(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// Probe connects the device to port and checks it answers the ResetDevice
+// handshake. The handshake isn't retried so ports without a device fail fast.
+func Probe(ctx context.Context, dev Device, port string) error {
+	err := dev.Connect(ctx, port)
+	if err != nil {
+		return err
+	}
+
+	if retrier, ok := dev.(Retrier); ok {
+		retrier.SetRetryPolicy(RetryPolicy{Attempts: 1})
+		defer retrier.SetRetryPolicy(DefaultRetryPolicy)
+	}
+
+	err = dev.ResetDevice(ctx)
+	if err != nil {
+		dev.Close()
+		return err
+	}
+
+	return nil
+}
+
+// Scan probes each port with the driver and returns the first device that
+// answers along with its port
+func Scan(ctx context.Context, driver string, ports []*PortInfo) (Device, *PortInfo, error) {
+	if len(ports) == 0 {
+		return nil, nil, fmt.Errorf("No serial ports found. Is the device plugged in?")
+	}
+
+	for _, port := range ports {
+		dev, err := New(driver)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		log.Debugf("Probing port %s", port)
+		err = Probe(ctx, dev, port.Path)
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return nil, nil, err
+		} else if err != nil {
+			log.Debugf("No %s device found at %s: %s", driver, port.Path, err)
+			continue
+		}
+
+		return dev, port, nil
+	}
+
+	return nil, nil, fmt.Errorf("No %s device found on %d serial ports", driver, len(ports))
+}
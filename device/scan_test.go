@@ -0,0 +1,176 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// deadPort is a serial port with nothing connected
+type deadPort struct{}
+
+func (p *deadPort) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (p *deadPort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// mockPortCMS50 connects to a MockCMS50 on /dev/ttyUSB1 and nothing on any
+// other port
+type mockPortCMS50 struct {
+	*CMS50
+}
+
+func (m *mockPortCMS50) Connect(ctx context.Context, port string) error {
+	if port == "/dev/ttyUSB1" {
+		m.device = &MockCMS50{}
+	} else {
+		m.device = &deadPort{}
+	}
+
+	return nil
+}
+
+func TestScan(t *testing.T) {
+	Register("test-scan", func() Device { return &mockPortCMS50{&CMS50{}} })
+	defer unregister("test-scan")
+
+	ports := []*PortInfo{
+		&PortInfo{Path: "/dev/ttyUSB0"},
+		&PortInfo{Path: "/dev/ttyUSB1"},
+		&PortInfo{Path: "/dev/ttyUSB2"},
+	}
+
+	dev, port, err := Scan(context.Background(), "test-scan", ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if port.Path != "/dev/ttyUSB1" {
+		t.Errorf("Invalid port found: got '%s' should be '%s'", port.Path, "/dev/ttyUSB1")
+	}
+
+	model, err := dev.GetModel(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if model != "50F" {
+		t.Errorf("Invalid model from scanned device: got '%s' should be '%s'", model, "50F")
+	}
+
+	_, _, err = Scan(context.Background(), "test-scan", ports[:1])
+	if err == nil {
+		t.Errorf("Expected error when no device answers")
+	}
+
+	_, _, err = Scan(context.Background(), "test-scan", nil)
+	if err == nil {
+		t.Errorf("Expected error when no ports found")
+	}
+}
+
+func TestListPorts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "myoxi-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fake device files with a persistent by-id link
+	devDir := filepath.Join(dir, "dev")
+	byID := filepath.Join(devDir, "serial", "by-id")
+	mustMkdir(t, byID)
+	mustWrite(t, filepath.Join(devDir, "ttyUSB0"), "")
+	mustWrite(t, filepath.Join(devDir, "ttyUSB1"), "")
+	mustSymlink(t, filepath.Join(devDir, "ttyUSB1"), filepath.Join(byID, "usb-Prolific_USB-Serial-if00-port0"))
+
+	// Fake sysfs tree for ttyUSB1
+	usbDev := filepath.Join(dir, "sys", "devices", "usb1", "1-1")
+	usbIface := filepath.Join(usbDev, "1-1:1.0", "ttyUSB1")
+	mustMkdir(t, usbIface)
+	mustWrite(t, filepath.Join(usbDev, "idVendor"), "067b\n")
+	mustWrite(t, filepath.Join(usbDev, "idProduct"), "2303\n")
+	mustWrite(t, filepath.Join(usbDev, "product"), "USB-Serial Controller\n")
+	sysfs := filepath.Join(dir, "sys", "class", "tty")
+	mustMkdir(t, filepath.Join(sysfs, "ttyUSB1"))
+	mustSymlink(t, usbIface, filepath.Join(sysfs, "ttyUSB1", "device"))
+
+	globs := []string{
+		filepath.Join(byID, "*"),
+		filepath.Join(devDir, "ttyUSB*"),
+	}
+
+	ports, err := listPorts(globs, sysfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ports) != 2 {
+		t.Fatalf("Invalid number of ports: got '%d' should be '%d'", len(ports), 2)
+	}
+
+	valid := PortInfo{
+		Path:    filepath.Join(devDir, "ttyUSB1"),
+		Link:    filepath.Join(byID, "usb-Prolific_USB-Serial-if00-port0"),
+		VID:     "067b",
+		PID:     "2303",
+		Product: "USB-Serial Controller",
+	}
+
+	if *ports[0] != valid {
+		t.Errorf("Invalid port: got '%+v' should be '%+v'", *ports[0], valid)
+	}
+
+	if ports[1].Path != filepath.Join(devDir, "ttyUSB0") || len(ports[1].VID) != 0 {
+		t.Errorf("Invalid port: got '%+v'", *ports[1])
+	}
+}
+
+func mustMkdir(t *testing.T, path string) {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustWrite(t *testing.T, path, data string) {
+	err := ioutil.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustSymlink(t *testing.T, oldname, newname string) {
+	err := os.Symlink(oldname, newname)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// SimulatorScheme is the port prefix used to select the simulator, for
+// example sim://?spo2=95&duration=6h
+const SimulatorScheme = "sim://"
+
+// SimulatorConfig controls the synthetic nights produced by the Simulator
+type SimulatorConfig struct {
+	// Number of sessions stored in the simulated device memory
+	Sessions int
+
+	// Length of each session
+	Duration time.Duration
+
+	// How often a sample is stored in the simulated device memory
+	Interval time.Duration
+
+	// Start time of the last session. Earlier sessions start one day apart
+	Start time.Time
+
+	// Baseline SpO2 and pulse
+	Spo2  int
+	Pulse int
+
+	// Number of clusters of desaturations per session and the number of
+	// desaturations in each cluster
+	Clusters int
+	Dips     int
+
+	// Number of finger out gaps per session
+	Gaps int
+
+	// Seed for the random number generator. The same seed always produces the
+	// same data
+	Seed int64
+
+	// Stream live data in real time. Otherwise live data is streamed as fast
+	// as possible
+	Realtime bool
+}
+
+// Simulator is a Device that needs no hardware. It produces synthetic nights
+// with a baseline SpO2, clusters of desaturations, pulse variability and
+// finger out gaps.
+type Simulator struct {
+	Config   SimulatorConfig
+	sessions []*simSession
+	skew     time.Duration
+}
+
+type simSession struct {
+	start   time.Time
+	records []*model.OxiRecord
+}
+
+func init() {
+	Register("sim", func() Device { return NewSimulator() })
+}
+
+func NewSimulator() *Simulator {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 23, 0, 0, 0, time.Local).AddDate(0, 0, -1)
+
+	return &Simulator{
+		Config: SimulatorConfig{
+			Sessions: 1,
+			Duration: 8 * time.Hour,
+			Interval: time.Second,
+			Start:    start,
+			Spo2:     96,
+			Pulse:    62,
+			Clusters: 3,
+			Dips:     5,
+			Gaps:     2,
+			Seed:     1,
+			Realtime: true,
+		},
+	}
+}
+
+// Connect parses the simulator options from the port query string and
+// generates the sessions stored in the device memory. Options are sessions,
+// duration, interval, start (RFC3339), spo2, pulse, clusters, dips, gaps, seed and
+// realtime.
+func (s *Simulator) Connect(ctx context.Context, port string) error {
+	u, err := url.Parse(port)
+	if err != nil {
+		return err
+	}
+
+	q := u.Query()
+	conf := &s.Config
+
+	for key := range q {
+		val := q.Get(key)
+		var err error
+		switch key {
+		case "sessions":
+			conf.Sessions, err = strconv.Atoi(val)
+		case "duration":
+			conf.Duration, err = time.ParseDuration(val)
+		case "interval":
+			conf.Interval, err = time.ParseDuration(val)
+		case "start":
+			conf.Start, err = time.Parse(time.RFC3339, val)
+		case "spo2":
+			conf.Spo2, err = strconv.Atoi(val)
+		case "pulse":
+			conf.Pulse, err = strconv.Atoi(val)
+		case "clusters":
+			conf.Clusters, err = strconv.Atoi(val)
+		case "dips":
+			conf.Dips, err = strconv.Atoi(val)
+		case "gaps":
+			conf.Gaps, err = strconv.Atoi(val)
+		case "seed":
+			conf.Seed, err = strconv.ParseInt(val, 10, 64)
+		case "realtime":
+			conf.Realtime, err = strconv.ParseBool(val)
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid simulator option %s=%s: %s", key, val, err)
+		}
+	}
+
+	if conf.Sessions < 0 || conf.Sessions > math.MaxUint8 {
+		return fmt.Errorf("Invalid number of simulator sessions: %d", conf.Sessions)
+	}
+
+	if conf.Interval < time.Second {
+		return fmt.Errorf("Invalid simulator sample interval: %s", conf.Interval)
+	}
+
+	if conf.Duration < 0 {
+		return fmt.Errorf("Invalid simulator session duration: %s", conf.Duration)
+	}
+
+	if conf.Clusters < 0 || conf.Dips < 0 || conf.Gaps < 0 {
+		return fmt.Errorf("Invalid number of simulator clusters, dips or gaps: %d, %d, %d", conf.Clusters, conf.Dips, conf.Gaps)
+	}
+
+	s.generate()
+
+	return nil
+}
+
+func (s *Simulator) Close() error {
+	return nil
+}
+
+func (s *Simulator) ResetDevice(ctx context.Context) error {
+	return nil
+}
+
+func (s *Simulator) GetModel(ctx context.Context) (string, error) {
+	return "SIM", nil
+}
+
+func (s *Simulator) GetUser(ctx context.Context) (string, error) {
+	return "simulator", nil
+}
+
+func (s *Simulator) GetIdentity(ctx context.Context) (*DeviceIdentity, error) {
+	return &DeviceIdentity{
+		Vendor:   "myoxi",
+		Model:    "SIM",
+		DeviceID: fmt.Sprintf("sim-%d", s.Config.Seed),
+		Info:     "Simulated oximeter",
+		User:     "simulator",
+	}, nil
+}
+
+func (s *Simulator) GetTime(ctx context.Context) (time.Time, error) {
+	return time.Now().Add(s.skew), nil
+}
+
+func (s *Simulator) SetTime(ctx context.Context, t time.Time) error {
+	s.skew = t.Sub(time.Now())
+	return nil
+}
+
+func (s *Simulator) EraseSessions(ctx context.Context) error {
+	s.sessions = nil
+	return nil
+}
+
+func (s *Simulator) GetSessionCount(ctx context.Context) (uint8, error) {
+	return uint8(len(s.sessions)), nil
+}
+
+func (s *Simulator) GetSessionDuration(ctx context.Context, session uint8) (time.Duration, error) {
+	sess, err := s.getSession(session)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(len(sess.records)) * s.Config.Interval, nil
+}
+
+func (s *Simulator) GetSampleInterval(ctx context.Context, session uint8) (time.Duration, error) {
+	if _, err := s.getSession(session); err != nil {
+		return 0, err
+	}
+
+	return s.Config.Interval, nil
+}
+
+func (s *Simulator) GetSessionTime(ctx context.Context, session uint8) (time.Time, error) {
+	sess, err := s.getSession(session)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return sess.start, nil
+}
+
+func (s *Simulator) GetSessionData(ctx context.Context, session uint8) ([]*model.OxiRecord, error) {
+	data := make([]*model.OxiRecord, 0)
+	err := s.StreamSessionData(ctx, session, collectRecords(&data))
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *Simulator) StreamSessionData(ctx context.Context, session uint8, handler RecordHandler) error {
+	sess, err := s.getSession(session)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range sess.records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Pass copies so callers can set the session ID and time
+		r := *rec
+		err := handler(&r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Simulator) getSession(session uint8) (*simSession, error) {
+	if int(session) >= len(s.sessions) {
+		return nil, fmt.Errorf("Session %d not found on device", session)
+	}
+
+	return s.sessions[session], nil
+}
+
+// StreamLiveData streams synthetic live data until ctx is done
+func (s *Simulator) StreamLiveData(ctx context.Context, handler RecordHandler, waveform WaveformHandler) error {
+	rnd := rand.New(rand.NewSource(s.Config.Seed))
+	start := time.Now()
+	step := time.Second / LiveDataRate
+
+	var ticker *time.Ticker
+	if s.Config.Realtime {
+		ticker = time.NewTicker(step)
+		defer ticker.Stop()
+	}
+
+	spo2, pulse := s.Config.Spo2, s.Config.Pulse
+	for packets := 0; ; packets++ {
+		if ticker != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+		}
+
+		now := start.Add(time.Second * time.Duration(packets) / LiveDataRate)
+
+		if waveform != nil {
+			// Pleth rises and falls once per heart beat
+			phase := now.Sub(start).Seconds() * float64(pulse) / 60
+			pleth := 50 + 40*math.Sin(2*math.Pi*phase)
+			err := waveform(&model.WaveformSample{DateTime: now, Pleth: uint8(pleth)})
+			if err != nil {
+				return err
+			}
+		}
+
+		if packets%LiveDataRate == 0 {
+			spo2 = clamp(s.Config.Spo2+rnd.Intn(3)-1, 0, 100)
+			pulse = clamp(s.Config.Pulse+rnd.Intn(7)-3, 30, 250)
+			err := handler(&model.OxiRecord{DateTime: now, Pulse: uint8(pulse), Spo2: uint8(spo2)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// generate creates the sessions stored in the simulated device memory
+func (s *Simulator) generate() {
+	conf := s.Config
+	rnd := rand.New(rand.NewSource(conf.Seed))
+	s.sessions = make([]*simSession, conf.Sessions)
+
+	for i := range s.sessions {
+		start := conf.Start.AddDate(0, 0, i-conf.Sessions+1)
+		s.sessions[i] = &simSession{start: start, records: s.generateNight(rnd)}
+		log.Debugf("Simulated session %d starting %s with %d records", i, start, len(s.sessions[i].records))
+	}
+}
+
+func (s *Simulator) generateNight(rnd *rand.Rand) []*model.OxiRecord {
+	conf := s.Config
+	n := int(conf.Duration.Seconds())
+
+	spo2 := make([]float64, n)
+	pulse := make([]float64, n)
+	for i := range spo2 {
+		spo2[i] = float64(conf.Spo2) + rnd.Float64()*2 - 1
+
+		// Pulse drifts slowly over a 90 minute sleep cycle
+		cycle := math.Sin(2 * math.Pi * float64(i) / 5400)
+		pulse[i] = float64(conf.Pulse) + 4*cycle + rnd.Float64()*6 - 3
+	}
+
+	// Desaturation clusters spread evenly through the night. Each dip drops
+	// 4-8% and recovers over 20-40 seconds with dips about a minute apart.
+	for c := 0; c < conf.Clusters; c++ {
+		pos := n * (2*c + 1) / (2 * conf.Clusters)
+		for d := 0; d < conf.Dips; d++ {
+			depth := 4 + rnd.Float64()*4
+			length := 20 + rnd.Intn(21)
+			for j := 0; j < length && pos+j < n; j++ {
+				shape := math.Sin(math.Pi * float64(j) / float64(length))
+				spo2[pos+j] -= depth * shape
+				pulse[pos+j] += 8 * shape
+			}
+			pos += length + 40 + rnd.Intn(30)
+		}
+	}
+
+	records := make([]*model.OxiRecord, n)
+	for i := range records {
+		records[i] = &model.OxiRecord{
+			Pulse: uint8(clamp(int(math.Round(pulse[i])), 30, 250)),
+			Spo2:  uint8(clamp(int(math.Round(spo2[i])), 0, 100)),
+		}
+	}
+
+	// Finger out gaps
+	for g := 0; g < conf.Gaps && n > 0; g++ {
+		pos := rnd.Intn(n)
+		length := 30 + rnd.Intn(91)
+		for j := pos; j < pos+length && j < n; j++ {
+			records[j].Pulse = 0
+			records[j].Spo2 = 0
+			records[j].Status = model.StatusFingerOut
+		}
+	}
+
+	// The night is generated one sample per second and the device stores
+	// every step samples
+	step := int(conf.Interval / time.Second)
+	if step <= 1 {
+		return records
+	}
+
+	stored := make([]*model.OxiRecord, 0, n/step)
+	for i := 0; i+step <= n; i += step {
+		stored = append(stored, records[i])
+	}
+
+	return stored
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+
+	return v
+}
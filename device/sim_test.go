@@ -0,0 +1,239 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+func newTestSimulator(t *testing.T, port string) *Simulator {
+	sim := NewSimulator()
+	err := sim.Connect(context.Background(), port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sim
+}
+
+func TestSimulatorSessions(t *testing.T) {
+	sim := newTestSimulator(t, "sim://?sessions=2&duration=2h&start=2018-11-24T23:00:00-05:00&seed=7")
+
+	count, err := sim.GetSessionCount(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if count != 2 {
+		t.Fatalf("Invalid session count: got '%d' should be '%d'", count, 2)
+	}
+
+	start, err := sim.GetSessionTime(context.Background(), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	validStart := time.Date(2018, time.November, 23, 23, 0, 0, 0, time.FixedZone("", -5*3600))
+	if !start.Equal(validStart) {
+		t.Errorf("Invalid session time: got '%s' should be '%s'", start, validStart)
+	}
+
+	duration, err := sim.GetSessionDuration(context.Background(), 1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if duration != 2*time.Hour {
+		t.Errorf("Invalid session duration: got '%s' should be '%s'", duration, 2*time.Hour)
+	}
+
+	data, err := sim.GetSessionData(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 7200 {
+		t.Fatalf("Invalid session data: got '%d' records should be '%d'", len(data), 7200)
+	}
+
+	fingerOut := 0
+	minSpo2 := uint8(100)
+	for _, rec := range data {
+		if rec.Status.Has(model.StatusFingerOut) {
+			fingerOut++
+			continue
+		}
+		if rec.Spo2 < minSpo2 {
+			minSpo2 = rec.Spo2
+		}
+	}
+
+	if fingerOut == 0 {
+		t.Errorf("Expected finger out gaps in simulated data")
+	}
+
+	if minSpo2 > 92 {
+		t.Errorf("Expected desaturations in simulated data: min spo2 %d", minSpo2)
+	}
+
+	// Same seed produces the same data
+	other := newTestSimulator(t, "sim://?sessions=2&duration=2h&start=2018-11-24T23:00:00-05:00&seed=7")
+	otherData, err := other.GetSessionData(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range data {
+		if *data[i] != *otherData[i] {
+			t.Fatalf("Simulated data differs with the same seed at record %d: '%s' != '%s'", i, data[i], otherData[i])
+		}
+	}
+
+	err = sim.EraseSessions(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	count, err = sim.GetSessionCount(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Invalid session count after erase: got '%d' should be '%d'", count, 0)
+	}
+}
+
+func TestSimulatorOptions(t *testing.T) {
+	sim := NewSimulator()
+	err := sim.Connect(context.Background(), "sim://?bogus=1")
+	if err == nil {
+		t.Errorf("Expected error for unknown simulator option")
+	}
+
+	err = sim.Connect(context.Background(), "sim://?spo2=high")
+	if err == nil {
+		t.Errorf("Expected error for invalid simulator option")
+	}
+
+	for _, opt := range []string{"duration=-1h", "clusters=-1", "dips=-2", "gaps=-3"} {
+		err = NewSimulator().Connect(context.Background(), "sim://?"+opt)
+		if err == nil {
+			t.Errorf("Expected error for negative simulator option %s", opt)
+		}
+	}
+
+	err = NewSimulator().Connect(context.Background(), "sim://?duration=0s")
+	if err != nil {
+		t.Errorf("Failed to connect to simulator with empty sessions: %s", err)
+	}
+}
+
+func TestSimulatorInterval(t *testing.T) {
+	sim := newTestSimulator(t, "sim://?duration=1h&interval=2s")
+
+	interval, err := sim.GetSampleInterval(context.Background(), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if interval != 2*time.Second {
+		t.Errorf("Invalid sample interval: got '%s' should be '%s'", interval, 2*time.Second)
+	}
+
+	duration, err := sim.GetSessionDuration(context.Background(), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if duration != time.Hour {
+		t.Errorf("Invalid session duration: got '%s' should be '%s'", duration, time.Hour)
+	}
+
+	data, err := sim.GetSessionData(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 1800 {
+		t.Errorf("Invalid session data: got '%d' records should be '%d'", len(data), 1800)
+	}
+
+	err = sim.Connect(context.Background(), "sim://?interval=10ms")
+	if err == nil {
+		t.Errorf("Expected error for sample interval under one second")
+	}
+}
+
+func TestSimulatorClock(t *testing.T) {
+	sim := newTestSimulator(t, "sim://")
+
+	err := sim.SetTime(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+
+	now, err := sim.GetTime(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	skew := time.Since(now)
+	if skew < 59*time.Minute || skew > 61*time.Minute {
+		t.Errorf("Invalid simulator clock skew: got '%s' should be '%s'", skew, time.Hour)
+	}
+}
+
+func TestSimulatorStreamLiveData(t *testing.T) {
+	sim := newTestSimulator(t, "sim://?realtime=false")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := make([]*model.OxiRecord, 0)
+	samples := 0
+	err := sim.StreamLiveData(ctx, func(rec *model.OxiRecord) error {
+		data = append(data, rec)
+		if len(data) == 3 {
+			cancel()
+		}
+		return nil
+	}, func(sample *model.WaveformSample) error {
+		samples++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(data) != 3 {
+		t.Fatalf("Invalid live data: got '%d' records should be '%d'", len(data), 3)
+	}
+
+	if data[2].DateTime.Sub(data[0].DateTime) != 2*time.Second {
+		t.Errorf("Invalid live data: records should be one second apart")
+	}
+
+	if samples != LiveDataRate*2+1 {
+		t.Errorf("Invalid waveform data: got '%d' samples should be '%d'", samples, LiveDataRate*2+1)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	// Telnet protocol bytes used by RFC2217
+	telnetIAC  = 0xff
+	telnetDONT = 0xfe
+	telnetDO   = 0xfd
+	telnetWONT = 0xfc
+	telnetWILL = 0xfb
+	telnetSB   = 0xfa
+	telnetSE   = 0xf0
+
+	telnetOptBinary  = 0x00
+	telnetOptSGA     = 0x03
+	telnetOptComPort = 0x2c
+	comPortSetBaud   = 0x01
+
+	// How long to wait for the TCP connection to be established
+	DialTimeout = time.Second * 10
+
+	// How long to wait for more pending input when flushing
+	FlushTimeout = time.Millisecond * 50
+)
+
+func init() {
+	RegisterTransport("tcp", openTCP)
+	RegisterTransport("rfc2217", openRFC2217)
+}
+
+// TCPTransport reads and writes raw bytes to a network serial bridge such as
+// ser2net in raw mode. The baud rate is configured on the bridge.
+type TCPTransport struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func openTCP(ctx context.Context, path string, u *url.URL, conf *TransportConfig) (Transport, error) {
+	dialer := &net.Dialer{Timeout: DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TCPTransport{conn: conn, timeout: conf.ReadTimeout}, nil
+}
+
+// Read reads from the connection. A read timeout returns io.EOF like a
+// serial port read timeout does.
+func (t *TCPTransport) Read(p []byte) (int, error) {
+	if t.timeout > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+	}
+
+	n, err := t.conn.Read(p)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+func (t *TCPTransport) Write(p []byte) (int, error) {
+	return t.conn.Write(p)
+}
+
+// Flush reads and discards input until none arrives for FlushTimeout
+func (t *TCPTransport) Flush() error {
+	buf := make([]byte, 1024)
+	for {
+		t.conn.SetReadDeadline(time.Now().Add(FlushTimeout))
+		_, err := t.conn.Read(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *TCPTransport) Close() error {
+	return t.conn.Close()
+}
+
+// RFC2217Transport talks to a telnet serial bridge using the RFC2217 COM port
+// option to set the baud rate. Telnet commands sent by the bridge are
+// answered and stripped from the data stream.
+type RFC2217Transport struct {
+	*TCPTransport
+	buf   []byte
+	state int
+	sub   []byte
+
+	// Replies already sent for each option so negotiation doesn't loop
+	replied map[[2]byte]bool
+}
+
+const (
+	rfcData = iota
+	rfcIAC
+	rfcOption
+	rfcSub
+	rfcSubIAC
+)
+
+func openRFC2217(ctx context.Context, path string, u *url.URL, conf *TransportConfig) (Transport, error) {
+	t, err := openTCP(ctx, path, u, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RFC2217Transport{
+		TCPTransport: t.(*TCPTransport),
+		replied: map[[2]byte]bool{
+			{telnetWILL, telnetOptBinary}:  true,
+			{telnetDO, telnetOptBinary}:    true,
+			{telnetWILL, telnetOptComPort}: true,
+		},
+	}
+
+	baud := uint32(conf.Baud)
+	_, err = r.conn.Write([]byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptComPort,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetBaud,
+		byte(baud >> 24), byte(baud >> 16), byte(baud >> 8), byte(baud),
+		telnetIAC, telnetSE,
+	})
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("Failed to negotiate RFC2217 options: %s", err)
+	}
+
+	return r, nil
+}
+
+// Read returns the serial data received from the bridge with telnet commands
+// removed
+func (r *RFC2217Transport) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		raw := make([]byte, len(p))
+		n, err := r.TCPTransport.Read(raw)
+		r.decode(raw[:n])
+		if err != nil && len(r.buf) == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Flush discards pending input including serial data already decoded
+func (r *RFC2217Transport) Flush() error {
+	r.buf = nil
+	return r.TCPTransport.Flush()
+}
+
+// Write escapes IAC bytes in p before sending them to the bridge
+func (r *RFC2217Transport) Write(p []byte) (int, error) {
+	_, err := r.conn.Write(bytes.Replace(p, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC}, -1))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (r *RFC2217Transport) decode(raw []byte) {
+	for _, b := range raw {
+		switch r.state {
+		case rfcData:
+			if b == telnetIAC {
+				r.state = rfcIAC
+			} else {
+				r.buf = append(r.buf, b)
+			}
+		case rfcIAC:
+			switch b {
+			case telnetIAC:
+				r.buf = append(r.buf, b)
+				r.state = rfcData
+			case telnetSB:
+				r.sub = r.sub[:0]
+				r.state = rfcSub
+			case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+				r.sub = append(r.sub[:0], b)
+				r.state = rfcOption
+			default:
+				r.state = rfcData
+			}
+		case rfcOption:
+			r.negotiate(r.sub[0], b)
+			r.state = rfcData
+		case rfcSub:
+			if b == telnetIAC {
+				r.state = rfcSubIAC
+			} else {
+				r.sub = append(r.sub, b)
+			}
+		case rfcSubIAC:
+			if b == telnetSE {
+				// Baud rate acks and line state notifications are ignored
+				r.state = rfcData
+			} else {
+				r.sub = append(r.sub, b)
+				r.state = rfcSub
+			}
+		}
+	}
+}
+
+// negotiate accepts the binary, suppress go ahead and COM port options and
+// refuses all others
+func (r *RFC2217Transport) negotiate(cmd, opt byte) {
+	supported := opt == telnetOptBinary || opt == telnetOptSGA || opt == telnetOptComPort
+
+	var reply byte
+	switch cmd {
+	case telnetDO:
+		reply = telnetWONT
+		if supported {
+			reply = telnetWILL
+		}
+	case telnetWILL:
+		reply = telnetDONT
+		if supported {
+			reply = telnetDO
+		}
+	default:
+		return
+	}
+
+	if r.replied[[2]byte{reply, opt}] {
+		return
+	}
+	r.replied[[2]byte{reply, opt}] = true
+
+	r.conn.Write([]byte{telnetIAC, reply, opt})
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Transport is a connection to a device
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// Flusher is implemented by transports that can discard input received but
+// not yet read, such as a late reply to a command that timed out
+type Flusher interface {
+	Flush() error
+}
+
+// flush discards pending input on rw if it supports it
+func flush(rw io.ReadWriter) error {
+	if flusher, ok := rw.(Flusher); ok {
+		return flusher.Flush()
+	}
+
+	return nil
+}
+
+// TransportConfig holds the connection settings for a transport
+type TransportConfig struct {
+	// Serial baud rate
+	Baud int
+
+	// How long a read waits for data before returning io.EOF
+	ReadTimeout time.Duration
+}
+
+// TransportOpener opens a transport to the device at the path given in the
+// port URL
+type TransportOpener func(ctx context.Context, path string, u *url.URL, conf *TransportConfig) (Transport, error)
+
+var (
+	transportsMu sync.RWMutex
+	transports   = make(map[string]TransportOpener)
+)
+
+func init() {
+	RegisterTransport("serial", openSerial)
+	RegisterTransport("file", openReplay)
+}
+
+// RegisterTransport makes a transport available by URL scheme. Register panics
+// if the same scheme is registered twice.
+func RegisterTransport(scheme string, opener TransportOpener) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if opener == nil {
+		panic("device: RegisterTransport opener is nil")
+	}
+	if _, dup := transports[scheme]; dup {
+		panic("device: RegisterTransport called twice for scheme " + scheme)
+	}
+
+	transports[scheme] = opener
+}
+
+// Transports returns a sorted list of the registered transport schemes
+func Transports() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+
+	schemes := make([]string, 0, len(transports))
+	for scheme := range transports {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// ParsePort parses a device port into a URL. A port without a scheme is the
+// path to a serial port. The baud and timeout query options override the
+// settings in conf.
+func ParsePort(port string, conf *TransportConfig) (*url.URL, string, error) {
+	if !strings.Contains(port, "://") {
+		return &url.URL{Scheme: "serial", Path: port}, port, nil
+	}
+
+	u, err := url.Parse(port)
+	if err != nil {
+		return nil, "", err
+	}
+
+	q := u.Query()
+	if baud := q.Get("baud"); len(baud) > 0 {
+		conf.Baud, err = strconv.Atoi(baud)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid baud rate %q: %s", baud, err)
+		}
+	}
+
+	if timeout := q.Get("timeout"); len(timeout) > 0 {
+		conf.ReadTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid read timeout %q: %s", timeout, err)
+		}
+	}
+
+	// Relative paths such as file://testdata/import.cap parse as a host
+	path := u.Host + u.Path
+	if len(u.Opaque) > 0 {
+		path = u.Opaque
+	}
+
+	return u, path, nil
+}
+
+// portInterval returns the sample interval set with the interval option of
+// the port URL, for example serial:///dev/ttyUSB0?interval=4s, or def if the
+// option is not set
+func portInterval(port string, def time.Duration) (time.Duration, error) {
+	if !strings.Contains(port, "://") {
+		return def, nil
+	}
+
+	u, err := url.Parse(port)
+	if err != nil {
+		return 0, err
+	}
+
+	val := u.Query().Get("interval")
+	if len(val) == 0 {
+		return def, nil
+	}
+
+	interval, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid sample interval %q: %s", val, err)
+	}
+
+	if interval < time.Second {
+		return 0, fmt.Errorf("Invalid sample interval %q: must be at least 1s", val)
+	}
+
+	return interval, nil
+}
+
+// OpenTransport opens the transport selected by the port URL scheme, for
+// example serial:///dev/ttyUSB0?baud=115200, tcp://host:port or
+// file://capture. conf holds the driver defaults.
+func OpenTransport(ctx context.Context, port string, conf TransportConfig) (Transport, error) {
+	u, path, err := ParsePort(port, &conf)
+	if err != nil {
+		return nil, err
+	}
+
+	transportsMu.RLock()
+	opener, ok := transports[u.Scheme]
+	transportsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown transport %q. Available transports: %v", u.Scheme, Transports())
+	}
+
+	return opener(ctx, path, u, &conf)
+}
+
+func openSerial(ctx context.Context, path string, u *url.URL, conf *TransportConfig) (Transport, error) {
+	return serial.OpenPort(&serial.Config{
+		Name:        path,
+		Baud:        conf.Baud,
+		ReadTimeout: conf.ReadTimeout,
+	})
+}
+
+func openReplay(ctx context.Context, path string, u *url.URL, conf *TransportConfig) (Transport, error) {
+	return OpenReplay(path)
+}
+
+// connect opens the transport for port and logs all traffic to capture if not
+// nil
+func connect(ctx context.Context, port string, conf TransportConfig, capture io.Writer) (io.ReadWriter, error) {
+	transport, err := OpenTransport(ctx, port, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if capture != nil {
+		return NewCaptureReadWriter(transport, capture), nil
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,273 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveMockCMS50 answers CMS50F commands received on the listener using
+// MockCMS50
+func serveMockCMS50(t *testing.T, ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	mock := &MockCMS50{}
+	cmd := make([]byte, 9)
+	res := make([]byte, 1024)
+	for {
+		_, err := io.ReadFull(conn, cmd)
+		if err != nil {
+			return
+		}
+
+		mock.Write(cmd)
+		n, _ := mock.Read(res)
+		conn.Write(res[:n])
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		port    string
+		scheme  string
+		path    string
+		baud    int
+		timeout time.Duration
+	}{
+		{"/dev/ttyUSB0", "serial", "/dev/ttyUSB0", 115200, time.Second * 5},
+		{"serial:///dev/ttyUSB1?baud=19200&timeout=2s", "serial", "/dev/ttyUSB1", 19200, time.Second * 2},
+		{"file://testdata/cms50f-import.cap", "file", "testdata/cms50f-import.cap", 115200, time.Second * 5},
+		{"file:///tmp/import.cap", "file", "/tmp/import.cap", 115200, time.Second * 5},
+		{"tcp://raspberrypi:2000?timeout=10s", "tcp", "", 115200, time.Second * 10},
+	}
+
+	for _, test := range tests {
+		conf := &TransportConfig{Baud: 115200, ReadTimeout: time.Second * 5}
+		u, path, err := ParsePort(test.port, conf)
+		if err != nil {
+			t.Errorf("Failed to parse port %s: %s", test.port, err)
+			continue
+		}
+
+		if u.Scheme != test.scheme {
+			t.Errorf("Invalid scheme for %s: got '%s' should be '%s'", test.port, u.Scheme, test.scheme)
+		}
+		if u.Scheme != "tcp" && path != test.path {
+			t.Errorf("Invalid path for %s: got '%s' should be '%s'", test.port, path, test.path)
+		}
+		if conf.Baud != test.baud {
+			t.Errorf("Invalid baud for %s: got '%d' should be '%d'", test.port, conf.Baud, test.baud)
+		}
+		if conf.ReadTimeout != test.timeout {
+			t.Errorf("Invalid timeout for %s: got '%s' should be '%s'", test.port, conf.ReadTimeout, test.timeout)
+		}
+	}
+
+	_, _, err := ParsePort("serial:///dev/ttyUSB0?baud=fast", &TransportConfig{})
+	if err == nil {
+		t.Errorf("Invalid baud rate should fail")
+	}
+
+	_, err = OpenTransport(context.Background(), "bluetooth://oximeter", TransportConfig{})
+	if err == nil {
+		t.Errorf("Unknown transport should fail")
+	}
+
+	interval, err := portInterval("serial:///dev/ttyUSB0?interval=4s", time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	if interval != 4*time.Second {
+		t.Errorf("Invalid sample interval: got '%s' should be '%s'", interval, 4*time.Second)
+	}
+
+	interval, err = portInterval("/dev/ttyUSB0", time.Second)
+	if err != nil || interval != time.Second {
+		t.Errorf("Invalid default sample interval: got '%s' should be '%s'", interval, time.Second)
+	}
+
+	_, err = portInterval("serial:///dev/ttyUSB0?interval=100ms", time.Second)
+	if err == nil {
+		t.Errorf("Sample interval under one second should fail")
+	}
+}
+
+func TestTCPTransport(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go serveMockCMS50(t, ln)
+
+	cms := &CMS50{}
+	err = cms.Connect(context.Background(), "tcp://"+ln.Addr().String()+"?timeout=500ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cms.Close()
+
+	model, err := cms.GetModel(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model != "50F" {
+		t.Errorf("Invalid model: got '%s' should be '%s'", model, "50F")
+	}
+
+	// Reads with no data should time out with EOF like a serial port
+	start := time.Now()
+	n, err := cms.device.Read(make([]byte, 10))
+	if n != 0 || err != io.EOF {
+		t.Errorf("Invalid read timeout: got '%d, %v' should be '0, EOF'", n, err)
+	}
+	if time.Since(start) > time.Second*2 {
+		t.Errorf("Read did not time out")
+	}
+}
+
+func TestTCPTransportFlush(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		// A late reply followed by the answer to the next command
+		conn.Write([]byte{0x01, 0x02, 0x03})
+		cmd := make([]byte, 1)
+		io.ReadFull(conn, cmd)
+		conn.Write([]byte{0x04})
+	}()
+
+	transport, err := OpenTransport(context.Background(), "tcp://"+ln.Addr().String()+"?timeout=1s", TransportConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	err = flush(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport.Write([]byte{0x7d})
+	buf := make([]byte, 10)
+	n, err := transport.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf[:n], []byte{0x04}) {
+		t.Errorf("Invalid data after flush: got '% x' should be '% x'", buf[:n], []byte{0x04})
+	}
+}
+
+func TestRFC2217Transport(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		// Ask for suppress go ahead then send data with an escaped 0xff
+		conn.Write([]byte{telnetIAC, telnetDO, telnetOptSGA, 0x01, telnetIAC, telnetIAC, 0x02})
+
+		buf := make([]byte, 1024)
+		var data []byte
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+			n, err := conn.Read(buf)
+			data = append(data, buf[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		received <- data
+	}()
+
+	transport, err := OpenTransport(context.Background(), "rfc2217://"+ln.Addr().String()+"?baud=19200&timeout=1s", TransportConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+
+	buf := make([]byte, 10)
+	var data []byte
+	for len(data) < 3 {
+		n, err := transport.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf[:n]...)
+	}
+
+	if !bytes.Equal(data, []byte{0x01, 0xff, 0x02}) {
+		t.Errorf("Invalid data: got '% x' should be '% x'", data, []byte{0x01, 0xff, 0x02})
+	}
+
+	_, err = transport.Write([]byte{0x7d, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := <-received
+
+	setBaud := []byte{telnetIAC, telnetSB, telnetOptComPort, comPortSetBaud, 0x00, 0x00, 0x4b, 0x00, telnetIAC, telnetSE}
+	if !bytes.Contains(sent, setBaud) {
+		t.Errorf("Missing set baud rate: got '% x'", sent)
+	}
+
+	if !bytes.Contains(sent, []byte{telnetIAC, telnetWILL, telnetOptSGA}) {
+		t.Errorf("Missing option reply: got '% x'", sent)
+	}
+
+	if !bytes.HasSuffix(sent, []byte{0x7d, telnetIAC, telnetIAC}) {
+		t.Errorf("Invalid escaped data: got '% x'", sent)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+// Contec's SpO2 Assistant and SpO2 Review Windows software save each recording
+// as a .SpO2 file. All values are little endian:
+//
+//	0x00  u16  offset of the first sample
+//	0x02  u16  file version
+//	0x04  u16  year of the start time
+//	0x06  u8   month, day, hour, minute and second of the start time
+//	0x0b  u8   seconds between samples. 0 is one second
+//	0x0c  u32  number of samples
+//	0x10       device model as a null terminated UTF-16 string
+//
+// Each sample is 2 bytes, SpO2 then pulse. SpO2 0x7f or pulse 0xff is finger
+// out. Times are the wall clock of the computer the file was downloaded on.
+//
+// The layout has not yet been checked against files saved by Contec's software
+// so the format is experimental and .SpO2 files are only read when chosen with
+// the contec-spo2 format name.
+const (
+	ContecVendor       = "CONTEC"
+	ContecHeaderSize   = 0x10
+	ContecSampleSize   = 2
+	ContecFormatSpO2   = "contec-spo2"
+	ContecFormatCSV    = "contec-csv"
+	contecDefaultModel = "CMS50D+"
+)
+
+// ContecSpO2Loader reads the .SpO2 session files saved by SpO2 Assistant and
+// SpO2 Review
+type ContecSpO2Loader struct{}
+
+// ContecCSVLoader reads the CSV files exported by SpO2 Assistant and SpO2
+// Review. The export starts with a block of "name,value" lines describing the
+// recording followed by a header row starting with Time and one sample per
+// line:
+//
+//	Model,CMS50D+
+//	Start Time,2018-11-28 22:14:03
+//	Time,SpO2(%),PR(bpm)
+//	22:14:03,96,62
+//
+// Sample times are either a time of day following on from the start time or
+// a full date and time. Samples with a SpO2 or pulse of "--" are finger out.
+type ContecCSVLoader struct{}
+
+func init() {
+	Register(ContecFormatSpO2, nil, func() Loader { return &ContecSpO2Loader{} })
+	MarkExperimental(ContecFormatSpO2)
+
+	// Contec CSV exports share the .csv extension with myoxi exports and are
+	// only read when chosen by name
+	Register(ContecFormatCSV, nil, func() Loader { return &ContecCSVLoader{} })
+}
+
+// newContecSession builds a session from samples taken every interval from
+// start
+func newContecSession(deviceModel, source string, start time.Time, interval int, records []*model.OxiRecord) *Session {
+	return &Session{
+		Session: &model.Session{
+			StartTime:      start,
+			Model:          deviceModel,
+			Vendor:         ContecVendor,
+			Seconds:        len(records) * interval,
+			SampleInterval: interval,
+			TimeZone:       zoneName(start),
+			Source:         source,
+		},
+		Records: records,
+	}
+}
+
+func newContecRecord(t time.Time, spo2, pulse uint8) *model.OxiRecord {
+	if pulse == 0xff || spo2 == 0x7f || spo2 > 100 || (pulse == 0 && spo2 == 0) {
+		return &model.OxiRecord{DateTime: t, Status: model.StatusFingerOut}
+	}
+
+	return &model.OxiRecord{DateTime: t, Pulse: pulse, Spo2: spo2}
+}
+
+func (l *ContecSpO2Loader) Load(r io.Reader) ([]*Session, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < ContecHeaderSize {
+		return nil, fmt.Errorf("File too short for a SpO2 header: %d bytes", len(data))
+	}
+
+	offset := int(binary.LittleEndian.Uint16(data[0:]))
+	if offset < ContecHeaderSize || offset > len(data) {
+		return nil, fmt.Errorf("Invalid sample offset %#x. Not a SpO2 file?", offset)
+	}
+
+	year := int(binary.LittleEndian.Uint16(data[4:]))
+	month, day, hour, minute, second := data[6], data[7], data[8], data[9], data[10]
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 59 {
+		return nil, fmt.Errorf("Invalid start time %04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, minute, second)
+	}
+	start := time.Date(year, time.Month(month), int(day), int(hour), int(minute), int(second), 0, time.Local)
+
+	interval := int(data[11])
+	if interval == 0 {
+		interval = 1
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[12:]))
+	samples := data[offset:]
+	if len(samples) < count*ContecSampleSize {
+		return nil, fmt.Errorf("File truncated: found %d of %d samples", len(samples)/ContecSampleSize, count)
+	}
+
+	deviceModel := utf16String(data[ContecHeaderSize:offset])
+	if len(deviceModel) == 0 {
+		deviceModel = contecDefaultModel
+	}
+
+	records := make([]*model.OxiRecord, 0, count)
+	for i := 0; i < count; i++ {
+		buf := samples[i*ContecSampleSize:]
+		t := start.Add(time.Duration(i*interval) * time.Second)
+		records = append(records, newContecRecord(t, buf[0], buf[1]))
+	}
+
+	if len(records) == 0 {
+		return []*Session{}, nil
+	}
+
+	return []*Session{newContecSession(deviceModel, ContecFormatSpO2, start, interval, records)}, nil
+}
+
+// utf16String decodes a null terminated little endian UTF-16 string
+func utf16String(buf []byte) string {
+	chars := make([]uint16, 0, len(buf)/2)
+	for i := 0; i+1 < len(buf); i += 2 {
+		c := binary.LittleEndian.Uint16(buf[i:])
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+
+	return strings.TrimSpace(string(utf16.Decode(chars)))
+}
+
+// Date and time layouts used in Contec CSV exports
+var contecTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+	"01/02/2006 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+func parseContecTime(value string) (time.Time, bool) {
+	for _, layout := range contecTimeLayouts {
+		t, err := time.ParseInLocation(layout, strings.TrimSpace(value), time.Local)
+		if err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func (l *ContecCSVLoader) Load(r io.Reader) ([]*Session, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	deviceModel := contecDefaultModel
+	var start time.Time
+	spo2Col, pulseCol := -1, -1
+
+	// Header block of name,value lines up to the column header row
+	line := 0
+	for spo2Col < 0 {
+		row, err := cr.Read()
+		line++
+		if err == io.EOF {
+			return nil, fmt.Errorf("Missing Time,SpO2,PR header row. Not a Contec CSV export?")
+		} else if err != nil {
+			return nil, err
+		}
+
+		name := strings.ToLower(strings.TrimSpace(row[0]))
+		switch {
+		case name == "time":
+			for i, col := range row {
+				col = strings.ToLower(strings.TrimSpace(col))
+				switch {
+				case strings.HasPrefix(col, "spo2"):
+					spo2Col = i
+				case strings.HasPrefix(col, "pr"), strings.HasPrefix(col, "pulse"):
+					pulseCol = i
+				}
+			}
+			if spo2Col < 0 || pulseCol < 0 {
+				return nil, fmt.Errorf("Missing SpO2 or PR column on line %d", line)
+			}
+		case len(row) < 2:
+		case name == "model" || name == "device":
+			if v := strings.TrimSpace(row[1]); len(v) > 0 {
+				deviceModel = v
+			}
+		case name == "start time" || name == "record time" || name == "start date":
+			t, ok := parseContecTime(row[1])
+			if !ok {
+				return nil, fmt.Errorf("Invalid start time on line %d: %s", line, row[1])
+			}
+			start = t
+		}
+	}
+
+	records := make([]*model.OxiRecord, 0)
+	var last time.Time
+	for {
+		row, err := cr.Read()
+		line++
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(row) <= spo2Col || len(row) <= pulseCol || len(strings.TrimSpace(row[0])) == 0 {
+			continue
+		}
+
+		t, ok := parseContecTime(row[0])
+		if !ok {
+			// Time of day following on from the previous sample
+			tod, err := time.Parse("15:04:05", strings.TrimSpace(row[0]))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid time on line %d: %s", line, row[0])
+			}
+
+			prev := last
+			if prev.IsZero() {
+				prev = start
+			}
+			if prev.IsZero() {
+				return nil, fmt.Errorf("Missing start time for time of day samples")
+			}
+
+			t = time.Date(prev.Year(), prev.Month(), prev.Day(), tod.Hour(), tod.Minute(), tod.Second(), 0, prev.Location())
+			if t.Before(prev) {
+				// Recording ran past midnight
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+
+		spo2, err := contecValue(row[spo2Col], 0x7f)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SpO2 on line %d: %s", line, err)
+		}
+
+		pulse, err := contecValue(row[pulseCol], 0xff)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pulse on line %d: %s", line, err)
+		}
+
+		records = append(records, newContecRecord(t, spo2, pulse))
+		last = t
+	}
+
+	if len(records) == 0 {
+		return []*Session{}, nil
+	}
+
+	s := newSession(records)
+	return []*Session{newContecSession(deviceModel, ContecFormatCSV, s.StartTime, s.SampleInterval, s.Records)}, nil
+}
+
+// contecValue parses a SpO2 or pulse value. Missing values are returned as
+// invalid
+func contecValue(value string, invalid uint8) (uint8, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 || strings.Trim(value, "-") == "" {
+		return invalid, nil
+	}
+
+	v, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint8(v), nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+func TestContecSpO2Loader(t *testing.T) {
+	path := filepath.Join("testdata", "contec-night.SpO2")
+	l, err := New(ContecFormatSpO2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := loadWith(t, l, path)
+	checkGolden(t, path, render(sessions))
+
+	if len(sessions) != 1 || sessions[0].TimeZone != model.LocalZoneName() {
+		t.Errorf("Invalid session time zone: got '%v' should be '%s'", sessions, model.LocalZoneName())
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the last sample
+	_, err = (&ContecSpO2Loader{}).Load(bytes.NewReader(data[:len(data)-1]))
+	if err == nil {
+		t.Errorf("Truncated file should fail")
+	}
+
+	// Month 13
+	bad := append([]byte{}, data...)
+	bad[6] = 13
+	_, err = (&ContecSpO2Loader{}).Load(bytes.NewReader(bad))
+	if err == nil {
+		t.Errorf("Invalid start time should fail")
+	}
+
+	bad = append([]byte{}, data...)
+	binary.LittleEndian.PutUint16(bad, uint16(len(data)+1))
+	_, err = (&ContecSpO2Loader{}).Load(bytes.NewReader(bad))
+	if err == nil {
+		t.Errorf("Invalid sample offset should fail")
+	}
+}
+
+func TestContecCSVLoader(t *testing.T) {
+	path := filepath.Join("testdata", "contec-export.csv")
+
+	l, err := New(ContecFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := loadWith(t, l, path)
+	checkGolden(t, path, render(sessions))
+
+	// Sample times with a date need no start time
+	sessions, err = l.Load(bytes.NewBufferString("Time,SpO2,Pulse\n2018-12-01 01:00:00,97,55\n2018-12-01 01:00:04,96,56\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 1 || sessions[0].SampleInterval != 4 || sessions[0].Seconds != 8 {
+		t.Errorf("Invalid session: got '%v'", sessions)
+	}
+
+	_, err = l.Load(bytes.NewBufferString("Time,SpO2,PR\n01:00:00,97,55\n"))
+	if err == nil {
+		t.Errorf("Time of day samples without a start time should fail")
+	}
+
+	_, err = l.Load(bytes.NewBufferString("date_time,session_id,pulse,spo2\n"))
+	if err == nil {
+		t.Errorf("Missing header row should fail")
+	}
+}
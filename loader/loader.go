@@ -0,0 +1,198 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package loader reads sessions from files exported by myoxi and other
+// oximetry software
+package loader
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+// Session is a session read from a file with its records
+type Session struct {
+	*model.Session
+	Records []*model.OxiRecord
+}
+
+func (s *Session) String() string {
+	return fmt.Sprintf("%s Records=%d", s.Session, len(s.Records))
+}
+
+// Loader reads the sessions stored in a file
+type Loader interface {
+	Load(r io.Reader) ([]*Session, error)
+}
+
+// Factory returns a new Loader
+type Factory func() Loader
+
+var (
+	loadersMu    sync.RWMutex
+	loaders      = make(map[string]Factory)
+	extensions   = make(map[string]string)
+	experimental = make(map[string]bool)
+)
+
+// Register makes a file format available by name. Files with one of exts are
+// read with the format unless another is chosen. Loaders call this from an
+// init function. Register panics if the same name or extension is registered
+// twice.
+func Register(name string, exts []string, factory Factory) {
+	loadersMu.Lock()
+	defer loadersMu.Unlock()
+
+	if factory == nil {
+		panic("loader: Register factory is nil")
+	}
+	if _, dup := loaders[name]; dup {
+		panic("loader: Register called twice for format " + name)
+	}
+
+	for _, ext := range exts {
+		ext = strings.ToLower(ext)
+		if other, dup := extensions[ext]; dup {
+			panic("loader: extension " + ext + " already registered for format " + other)
+		}
+		extensions[ext] = name
+	}
+
+	loaders[name] = factory
+}
+
+// MarkExperimental flags the format registered by name as not yet checked
+// against files saved by the software that writes it. Experimental formats
+// should be registered without extensions so they are only read when chosen by
+// name.
+func MarkExperimental(name string) {
+	loadersMu.Lock()
+	defer loadersMu.Unlock()
+
+	experimental[name] = true
+}
+
+// Experimental returns true if the format registered by name is experimental
+func Experimental(name string) bool {
+	loadersMu.RLock()
+	defer loadersMu.RUnlock()
+
+	return experimental[name]
+}
+
+// New returns a new Loader for the format registered by name
+func New(name string) (Loader, error) {
+	loadersMu.RLock()
+	factory, ok := loaders[name]
+	loadersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown file format %q. Available formats: %v", name, Formats())
+	}
+
+	return factory(), nil
+}
+
+// ForFile returns a new Loader for the format registered for the extension of
+// path
+func ForFile(path string) (Loader, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	loadersMu.RLock()
+	name, ok := extensions[ext]
+	loadersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown file format for %s. Choose one of %v", path, Formats())
+	}
+
+	return New(name)
+}
+
+// Formats returns a sorted list of the names of the registered formats
+func Formats() []string {
+	loadersMu.RLock()
+	defer loadersMu.RUnlock()
+
+	names := make([]string, 0, len(loaders))
+	for name := range loaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// newSession builds a session from records sorted by time. The sample interval
+// is the most common time between records and the time zone is taken from the
+// first record.
+func newSession(records []*model.OxiRecord) *Session {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].DateTime.Before(records[j].DateTime)
+	})
+
+	start := records[0].DateTime
+	interval := sampleInterval(records)
+
+	return &Session{
+		Session: &model.Session{
+			StartTime:      start,
+			Seconds:        len(records) * interval,
+			SampleInterval: interval,
+			TimeZone:       zoneName(start),
+		},
+		Records: records,
+	}
+}
+
+// sampleInterval returns the most common number of seconds between records
+func sampleInterval(records []*model.OxiRecord) int {
+	counts := make(map[int]int)
+	for i := 1; i < len(records); i++ {
+		delta := int(records[i].DateTime.Sub(records[i-1].DateTime).Round(time.Second) / time.Second)
+		if delta > 0 {
+			counts[delta]++
+		}
+	}
+
+	interval, most := 1, 0
+	for delta, count := range counts {
+		if count > most || (count == most && delta < interval) {
+			interval, most = delta, count
+		}
+	}
+
+	return interval
+}
+
+// zoneName returns the local time zone name if t has the local UTC offset or
+// the UTC offset of t otherwise
+func zoneName(t time.Time) string {
+	_, offset := t.Zone()
+	if _, local := t.In(time.Local).Zone(); local == offset {
+		return model.LocalZoneName()
+	}
+
+	return t.Format("-07:00")
+}
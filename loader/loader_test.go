@@ -0,0 +1,97 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+package loader
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Golden files are regenerated with go test ./loader -update. The binary and
+// vendor CSV fixtures in testdata are synthetic files built from the format
+// descriptions in the loaders, not recordings saved by the vendor software.
+// See testdata/README.md before updating them.
+var update = flag.Bool("update", false, "update golden files")
+
+func loadFile(t *testing.T, path string) []*Session {
+	l, err := ForFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loadWith(t, l, path)
+}
+
+// loadWith reads path with the loader for a format that is not chosen by
+// extension
+func loadWith(t *testing.T, l Loader, path string) []*Session {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sessions, err := l.Load(f)
+	if err != nil {
+		t.Fatalf("Failed to load %s: %s", path, err)
+	}
+
+	return sessions
+}
+
+// render writes sessions and their records as text for comparing with golden
+// files. Times are wall clock times so the output does not depend on the local
+// time zone.
+func render(sessions []*Session) []byte {
+	var buf bytes.Buffer
+	for _, s := range sessions {
+		fmt.Fprintf(&buf, "Start=%s Model=%s Vendor=%s Source=%s Interval=%d Seconds=%d Records=%d\n",
+			s.StartTime.Format("2006-01-02 15:04:05"), s.Model, s.Vendor, s.Source, s.SampleInterval, s.Seconds, len(s.Records))
+		for _, rec := range s.Records {
+			if rec.Motion > 0 {
+				fmt.Fprintf(&buf, "%s Motion=%d\n", rec, rec.Motion)
+				continue
+			}
+			fmt.Fprintln(&buf, rec)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func checkGolden(t *testing.T, path string, got []byte) {
+	golden := path + ".golden"
+	if *update {
+		err := ioutil.WriteFile(golden, got, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("Invalid sessions for %s: got\n%s\nshould be\n%s", path, got, want)
+	}
+}
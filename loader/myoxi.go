@@ -0,0 +1,331 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+// MyoxiLoader reads files written by the myoxi export command in any of the
+// csv, tsv, json or ndjson formats. Sessions are rebuilt from the session_id
+// of each record. The device, sample interval, time zone, clock drift and
+// source of each session are read from the session columns or the session
+// object of JSON exports. Exports without them get the sample interval and
+// time zone from the record times.
+type MyoxiLoader struct{}
+
+// jsonRecord is a record in a JSON export. The first record of each session
+// holds the session.
+type jsonRecord struct {
+	model.OxiRecord
+	Session *model.Session `json:"session"`
+}
+
+// sessionColumns are the session columns of CSV and TSV exports
+var sessionColumns = []string{
+	"model", "vendor", "device_id", "device_info", "sample_interval",
+	"time_zone", "clock_drift", "drift_correction", "source",
+}
+
+func init() {
+	Register("myoxi", []string{".csv", ".tsv", ".json", ".ndjson"}, func() Loader { return &MyoxiLoader{} })
+}
+
+func (l *MyoxiLoader) Load(r io.Reader) ([]*Session, error) {
+	br := bufio.NewReader(r)
+
+	var records []*model.OxiRecord
+	var details map[int64]*model.Session
+	var err error
+	switch firstByte(br) {
+	case '[':
+		records, details, err = readJSONArray(br)
+	case '{':
+		records, details, err = readJSONLines(br)
+	default:
+		records, details, err = readDelimitedRecords(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return groupSessions(records, details), nil
+}
+
+// firstByte returns the first non-space byte without consuming it
+func firstByte(br *bufio.Reader) byte {
+	for i := 1; ; i++ {
+		buf, _ := br.Peek(i)
+		if len(buf) < i {
+			return 0
+		}
+
+		switch c := buf[i-1]; c {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return c
+		}
+	}
+}
+
+// readJSONArray reads a JSON array of records and the sessions they hold
+func readJSONArray(r io.Reader) ([]*model.OxiRecord, map[int64]*model.Session, error) {
+	dec := json.NewDecoder(r)
+	_, err := dec.Token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse JSON: %s", err)
+	}
+
+	records := make([]*model.OxiRecord, 0)
+	details := make(map[int64]*model.Session)
+	for dec.More() {
+		rec := &jsonRecord{}
+		err := dec.Decode(rec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse JSON record %d: %s", len(records)+1, err)
+		}
+		if rec.Session != nil {
+			details[rec.SessionID] = rec.Session
+		}
+		records = append(records, &rec.OxiRecord)
+	}
+
+	return records, details, nil
+}
+
+// readJSONLines reads one JSON record per line and the sessions they hold
+func readJSONLines(r io.Reader) ([]*model.OxiRecord, map[int64]*model.Session, error) {
+	dec := json.NewDecoder(r)
+	records := make([]*model.OxiRecord, 0)
+	details := make(map[int64]*model.Session)
+	for {
+		rec := &jsonRecord{}
+		err := dec.Decode(rec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse JSON record %d: %s", len(records)+1, err)
+		}
+		if rec.Session != nil {
+			details[rec.SessionID] = rec.Session
+		}
+		records = append(records, &rec.OxiRecord)
+	}
+
+	return records, details, nil
+}
+
+// readDelimitedRecords reads CSV or TSV records with a header row and the
+// sessions from the session columns if the export has them
+func readDelimitedRecords(br *bufio.Reader) ([]*model.OxiRecord, map[int64]*model.Session, error) {
+	// A tab in the header row selects TSV
+	header, _ := br.Peek(512)
+
+	cr := csv.NewReader(br)
+	if bytes.IndexByte(firstLine(header), '\t') >= 0 {
+		cr.Comma = '\t'
+	}
+
+	columns, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read header: %s", err)
+	}
+
+	index := make(map[string]int)
+	for i, name := range columns {
+		index[name] = i
+	}
+
+	for _, name := range []string{"date_time", "session_id", "pulse", "spo2"} {
+		if _, ok := index[name]; !ok {
+			return nil, nil, fmt.Errorf("Missing column %s. Not a myoxi export?", name)
+		}
+	}
+
+	// Exports from before the session columns have none of them
+	hasSession := true
+	for _, name := range sessionColumns {
+		if _, ok := index[name]; !ok {
+			hasSession = false
+		}
+	}
+
+	records := make([]*model.OxiRecord, 0)
+	details := make(map[int64]*model.Session)
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		rec, err := parseRow(row, index)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse line %d: %s", line, err)
+		}
+		records = append(records, rec)
+
+		if _, ok := details[rec.SessionID]; hasSession && !ok {
+			details[rec.SessionID], err = parseSession(row, index)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to parse line %d: %s", line, err)
+			}
+		}
+	}
+
+	return records, details, nil
+}
+
+func firstLine(buf []byte) []byte {
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return buf[:i]
+	}
+
+	return buf
+}
+
+func parseRow(row []string, index map[string]int) (*model.OxiRecord, error) {
+	rec := &model.OxiRecord{}
+
+	var err error
+	rec.DateTime, err = time.Parse(time.RFC3339, row[index["date_time"]])
+	if err != nil {
+		return nil, err
+	}
+
+	rec.SessionID, err = strconv.ParseInt(row[index["session_id"]], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	pulse, err := strconv.ParseUint(row[index["pulse"]], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	rec.Pulse = uint8(pulse)
+
+	spo2, err := strconv.ParseUint(row[index["spo2"]], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	rec.Spo2 = uint8(spo2)
+
+	if i, ok := index["status"]; ok {
+		status, err := strconv.ParseUint(row[i], 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		rec.Status = model.Status(status)
+	}
+
+	if i, ok := index["motion"]; ok {
+		motion, err := strconv.ParseUint(row[i], 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		rec.Motion = uint8(motion)
+	}
+
+	return rec, nil
+}
+
+// parseSession reads the session columns of a row
+func parseSession(row []string, index map[string]int) (*model.Session, error) {
+	session := &model.Session{
+		Model:      row[index["model"]],
+		Vendor:     row[index["vendor"]],
+		DeviceID:   row[index["device_id"]],
+		DeviceInfo: row[index["device_info"]],
+		TimeZone:   row[index["time_zone"]],
+		Source:     row[index["source"]],
+	}
+
+	var err error
+	session.SampleInterval, err = strconv.Atoi(row[index["sample_interval"]])
+	if err != nil {
+		return nil, err
+	}
+
+	session.ClockDrift, err = strconv.Atoi(row[index["clock_drift"]])
+	if err != nil {
+		return nil, err
+	}
+
+	session.DriftCorrection, err = strconv.Atoi(row[index["drift_correction"]])
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// groupSessions builds a session for each session ID in order of first
+// appearance and applies the details read for the session
+func groupSessions(records []*model.OxiRecord, details map[int64]*model.Session) []*Session {
+	order := make([]int64, 0)
+	groups := make(map[int64][]*model.OxiRecord)
+	for _, rec := range records {
+		if _, ok := groups[rec.SessionID]; !ok {
+			order = append(order, rec.SessionID)
+		}
+		groups[rec.SessionID] = append(groups[rec.SessionID], rec)
+	}
+
+	sessions := make([]*Session, 0, len(order))
+	for _, id := range order {
+		s := newSession(groups[id])
+		if d, ok := details[id]; ok {
+			applyDetails(s, d)
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+// applyDetails copies the device, sample interval, time zone, clock drift and
+// source of an exported session to s. The start time and duration are kept
+// from the records as an export may hold only part of a session.
+func applyDetails(s *Session, d *model.Session) {
+	s.Model = d.Model
+	s.Vendor = d.Vendor
+	s.DeviceID = d.DeviceID
+	s.DeviceInfo = d.DeviceInfo
+	s.ClockDrift = d.ClockDrift
+	s.DriftCorrection = d.DriftCorrection
+	s.Source = d.Source
+
+	if d.SampleInterval > 0 {
+		s.SampleInterval = d.SampleInterval
+		s.Seconds = len(s.Records) * d.SampleInterval
+	}
+
+	if _, err := model.LoadLocation(d.TimeZone); len(d.TimeZone) > 0 && err == nil {
+		s.TimeZone = d.TimeZone
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+func TestMyoxiLoader(t *testing.T) {
+	for _, ext := range []string{"csv", "tsv", "json", "ndjson"} {
+		path := filepath.Join("testdata", "myoxi-export."+ext)
+		sessions := loadFile(t, path)
+
+		if len(sessions) != 2 {
+			t.Fatalf("Invalid number of sessions in %s: got '%d' should be '%d'", path, len(sessions), 2)
+		}
+
+		first, second := sessions[0], sessions[1]
+
+		start := time.Date(2018, time.November, 25, 4, 0, 0, 0, time.UTC)
+		if !first.StartTime.Equal(start) || first.TimeZone != "America/New_York" {
+			t.Errorf("Invalid session start in %s: got '%s' (%s) should be '%s' (%s)", path, first.StartTime, first.TimeZone, start, "America/New_York")
+		}
+
+		if first.Vendor != "Contec" || first.Model != "50F" || first.DeviceID != "0154321" || first.DeviceInfo != "CMS50F, firmware 5.4" {
+			t.Errorf("Invalid session device in %s: got '%s' '%s' '%s' '%s'", path, first.Vendor, first.Model, first.DeviceID, first.DeviceInfo)
+		}
+
+		if first.ClockDrift != 3 || first.DriftCorrection != 2 || len(first.Source) != 0 {
+			t.Errorf("Invalid session drift in %s: got '%d' '%d' source '%s'", path, first.ClockDrift, first.DriftCorrection, first.Source)
+		}
+
+		if len(first.Records) != 4 || first.SampleInterval != 1 || first.Seconds != 4 {
+			t.Errorf("Invalid session in %s: got '%d' records every '%d's for '%d's", path, len(first.Records), first.SampleInterval, first.Seconds)
+		}
+
+		if !first.Records[2].Status.Has(model.StatusFingerOut) {
+			t.Errorf("Invalid record status in %s: got '%s' should be '%s'", path, first.Records[2].Status, model.StatusFingerOut)
+		}
+
+		if len(second.Records) != 3 || second.SampleInterval != 4 || second.Seconds != 12 || second.TimeZone != "Europe/Paris" || second.Source != O2RingFormat {
+			t.Errorf("Invalid session in %s: got '%d' records every '%d's for '%d's in '%s'", path, len(second.Records), second.SampleInterval, second.Seconds, second.TimeZone)
+		}
+
+		if second.Records[1].Spo2 != 93 || second.Records[1].Status != model.StatusSearching || second.Records[1].Motion != 12 {
+			t.Errorf("Invalid record in %s: got '%s' motion '%d'", path, second.Records[1], second.Records[1].Motion)
+		}
+	}
+
+	// Exports from before the motion column
+	sessions, err := (&MyoxiLoader{}).Load(strings.NewReader("date_time,session_id,pulse,spo2,status\n2018-11-24T23:00:00-05:00,3,62,96,0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 1 || len(sessions[0].Records) != 1 || sessions[0].Records[0].Motion != 0 {
+		t.Errorf("Invalid sessions without motion: got '%v'", sessions)
+	}
+
+	// Exports from before the session columns take the time zone from the
+	// record times. Offsets matching the local zone are stored under the
+	// local zone name
+	start := time.Date(2018, time.November, 25, 4, 0, 0, 0, time.UTC)
+	zone := zoneName(start.In(time.FixedZone("", -5*60*60)))
+	if sessions[0].TimeZone != zone || len(sessions[0].Model) != 0 {
+		t.Errorf("Invalid session without session columns: got '%s' model '%s' should be '%s'", sessions[0].TimeZone, sessions[0].Model, zone)
+	}
+
+	sessions, err = (&MyoxiLoader{}).Load(strings.NewReader(`{"date_time":"2018-11-24T23:00:00-05:00","session_id":3,"pulse":62,"spo2":96,"status":0}` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 1 || sessions[0].TimeZone != zone || sessions[0].SampleInterval != 1 {
+		t.Errorf("Invalid JSON session without session object: got '%v'", sessions)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	_, err := ForFile("night.xyz")
+	if err == nil {
+		t.Errorf("Expected error for unknown file extension")
+	}
+
+	_, err = New("bogus")
+	if err == nil {
+		t.Errorf("Expected error for unknown file format")
+	}
+
+	l, err := ForFile("EXPORT.CSV")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := l.(*MyoxiLoader); !ok {
+		t.Errorf("Invalid loader for csv file: got '%T'", l)
+	}
+
+	// Experimental formats are only read when chosen by name
+	_, err = ForFile("20181128.SpO2")
+	if err == nil {
+		t.Errorf("Expected error for experimental SpO2 file extension")
+	}
+
+	_, err = ForFile("20190302234108.dat")
+	if err == nil {
+		t.Errorf("Expected error for experimental O2Ring file extension")
+	}
+
+	if !Experimental(ContecFormatSpO2) || !Experimental(O2RingFormat) || Experimental("myoxi") {
+		t.Errorf("Invalid experimental formats")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+// The Wellue/Viatom O2Ring saves each recording as a binary file. All values
+// are little endian:
+//
+//	0x00  u16  file version
+//	0x02  u16  year of the start time
+//	0x04  u8   month, day, hour, minute and second of the start time
+//	0x09  u32  file size in bytes
+//	0x0d  u16  duration of the recording in seconds
+//	0x28       first sample
+//
+// Each sample is 5 bytes: SpO2, pulse, invalid flag, motion and vibration.
+// The ring stores a sample every 4 seconds. Samples with the invalid flag set
+// or a SpO2 or pulse of 0xff were taken with the ring off the finger. Times
+// are the wall clock of the phone the ring was synced with.
+//
+// The size and duration offsets and the sample layout have only been read from
+// synthetic files. Until they are checked against recordings from a ring the
+// format is experimental and files are only read with the o2ring format name.
+const (
+	O2RingVendor          = "Viatom"
+	O2RingModel           = "O2Ring"
+	O2RingFormat          = "o2ring"
+	O2RingHeaderSize      = 0x28
+	O2RingSampleSize      = 5
+	O2RingDefaultInterval = 4
+)
+
+// O2RingLoader reads the .dat recordings of the Wellue/Viatom O2Ring. The
+// motion channel is kept on each record. Vibration alerts are not stored.
+type O2RingLoader struct{}
+
+func init() {
+	Register(O2RingFormat, nil, func() Loader { return &O2RingLoader{} })
+	MarkExperimental(O2RingFormat)
+}
+
+func (l *O2RingLoader) Load(r io.Reader) ([]*Session, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < O2RingHeaderSize {
+		return nil, fmt.Errorf("File too short for an O2Ring header: %d bytes", len(data))
+	}
+
+	year := int(binary.LittleEndian.Uint16(data[2:]))
+	month, day, hour, minute, second := data[4], data[5], data[6], data[7], data[8]
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 59 {
+		return nil, fmt.Errorf("Invalid start time %04d-%02d-%02d %02d:%02d:%02d. Not an O2Ring file?", year, month, day, hour, minute, second)
+	}
+	start := time.Date(year, time.Month(month), int(day), int(hour), int(minute), int(second), 0, time.Local)
+
+	size := int(binary.LittleEndian.Uint32(data[9:]))
+	if size > len(data) {
+		return nil, fmt.Errorf("File truncated: got %d of %d bytes", len(data), size)
+	}
+	if size < O2RingHeaderSize {
+		size = len(data)
+	}
+
+	samples := data[O2RingHeaderSize:size]
+	count := len(samples) / O2RingSampleSize
+
+	// Use the recorded duration for rings set to another sample rate
+	interval := O2RingDefaultInterval
+	duration := int(binary.LittleEndian.Uint16(data[13:]))
+	if duration > 0 && count > 0 {
+		interval = (duration + count/2) / count
+		if interval < 1 {
+			interval = 1
+		}
+	}
+
+	records := make([]*model.OxiRecord, 0, count)
+	for i := 0; i < count; i++ {
+		buf := samples[i*O2RingSampleSize:]
+		rec := &model.OxiRecord{
+			DateTime: start.Add(time.Duration(i*interval) * time.Second),
+			Spo2:     buf[0],
+			Pulse:    buf[1],
+			Motion:   buf[3],
+		}
+
+		if buf[2] != 0 || buf[0] == 0xff || buf[1] == 0xff || buf[0] > 100 {
+			rec.Spo2, rec.Pulse = 0, 0
+			rec.Status = model.StatusFingerOut
+		}
+
+		records = append(records, rec)
+	}
+
+	if len(records) == 0 {
+		return []*Session{}, nil
+	}
+
+	return []*Session{{
+		Session: &model.Session{
+			StartTime:      start,
+			Model:          O2RingModel,
+			Vendor:         O2RingVendor,
+			Seconds:        len(records) * interval,
+			SampleInterval: interval,
+			TimeZone:       zoneName(start),
+			Source:         O2RingFormat,
+		},
+		Records: records,
+	}}, nil
+}
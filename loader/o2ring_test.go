@@ -0,0 +1,64 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestO2RingLoader(t *testing.T) {
+	path := filepath.Join("testdata", "o2ring-night.dat")
+	l, err := New(O2RingFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := loadWith(t, l, path)
+	checkGolden(t, path, render(sessions))
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = (&O2RingLoader{}).Load(bytes.NewReader(data[:len(data)-1]))
+	if err == nil {
+		t.Errorf("Truncated file should fail")
+	}
+
+	_, err = (&O2RingLoader{}).Load(bytes.NewReader(data[:O2RingHeaderSize-1]))
+	if err == nil {
+		t.Errorf("Short header should fail")
+	}
+
+	// Same samples recorded every 2 seconds
+	fast := append([]byte{}, data...)
+	binary.LittleEndian.PutUint16(fast[13:], 20)
+	sessions, err = (&O2RingLoader{}).Load(bytes.NewReader(fast))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sessions[0].SampleInterval != 2 || sessions[0].Seconds != 20 {
+		t.Errorf("Invalid sample interval: got '%d' should be '%d'", sessions[0].SampleInterval, 2)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+const OSCARFormat = "oscar"
+
+// OSCARLoader reads oximetry from the CSV files written by OSCAR's and
+// SleepyHead's Export CSV with the Details option. Each line is one event of a
+// session:
+//
+//	DateTime,Session,Event,Data/Duration
+//	2018-11-24T23:00:00,1543118400,SPO2,96
+//	2018-11-24T23:00:00,1543118400,Pulse,62
+//
+// SpO2 and pulse events with the same time are merged into one record and all
+// other events are skipped. Older SleepyHead versions write the date with a
+// space instead of a T and some write one line per time with SpO2 and Pulse
+// columns instead of Event and Data. Times are local wall clock times. A
+// SpO2 or pulse of 0 is finger out.
+type OSCARLoader struct{}
+
+func init() {
+	// OSCAR exports share the .csv extension with myoxi exports and are only
+	// read when chosen by name
+	Register(OSCARFormat, nil, func() Loader { return &OSCARLoader{} })
+}
+
+// Date and time layouts used in OSCAR and SleepyHead exports
+var oscarTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+func parseOSCARTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range oscarTimeLayouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, strings.TrimSpace(value), time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
+// parseOSCARValue parses a SpO2 or pulse value which may be written with
+// decimals
+func parseOSCARValue(value string) (uint8, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if v < 0 || v > 255 {
+		return 0, fmt.Errorf("Value out of range: %s", value)
+	}
+
+	return uint8(math.Round(v)), nil
+}
+
+// oscarSessions collects the records of each session in order of first
+// appearance, merging SpO2 and pulse values with the same time
+type oscarSessions struct {
+	order   []int64
+	records map[int64][]*model.OxiRecord
+	index   map[int64]map[int64]*model.OxiRecord
+}
+
+func (o *oscarSessions) record(session int64, t time.Time) *model.OxiRecord {
+	if _, ok := o.index[session]; !ok {
+		o.order = append(o.order, session)
+		o.index[session] = make(map[int64]*model.OxiRecord)
+	}
+
+	rec, ok := o.index[session][t.Unix()]
+	if !ok {
+		rec = &model.OxiRecord{DateTime: t}
+		o.index[session][t.Unix()] = rec
+		o.records[session] = append(o.records[session], rec)
+	}
+
+	return rec
+}
+
+func (l *OSCARLoader) Load(r io.Reader) ([]*Session, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read header: %s", err)
+	}
+
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	dateCol, ok := index["datetime"]
+	if !ok {
+		return nil, fmt.Errorf("Missing DateTime column. Not an OSCAR details export?")
+	}
+
+	sessionCol, ok := index["session"]
+	if !ok {
+		return nil, fmt.Errorf("Missing Session column. Not an OSCAR details export?")
+	}
+
+	// Event,Data/Duration lines or one line per time with SpO2 and Pulse columns
+	eventCol, events := index["event"]
+	dataCol, ok := index["data/duration"]
+	if !ok {
+		dataCol, ok = index["data"]
+	}
+	spo2Col, hasSpo2 := index["spo2"]
+	pulseCol, hasPulse := index["pulse"]
+	if !(events && ok) && !(hasSpo2 && hasPulse) {
+		return nil, fmt.Errorf("Missing Event and Data/Duration or SpO2 and Pulse columns. Not an OSCAR details export?")
+	}
+
+	sessions := &oscarSessions{
+		records: make(map[int64][]*model.OxiRecord),
+		index:   make(map[int64]map[int64]*model.OxiRecord),
+	}
+
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(row) < len(header) {
+			continue
+		}
+
+		var values map[string]string
+		if events {
+			event := strings.ToLower(strings.TrimSpace(row[eventCol]))
+			switch event {
+			case "spo2":
+				values = map[string]string{"spo2": row[dataCol]}
+			case "pulse", "pr":
+				values = map[string]string{"pulse": row[dataCol]}
+			default:
+				continue
+			}
+		} else {
+			values = map[string]string{"spo2": row[spo2Col], "pulse": row[pulseCol]}
+		}
+
+		t, err := parseOSCARTime(row[dateCol])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time on line %d: %s", line, err)
+		}
+
+		id, err := strconv.ParseInt(strings.TrimSpace(row[sessionCol]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid session on line %d: %s", line, err)
+		}
+
+		rec := sessions.record(id, t)
+		for name, value := range values {
+			v, err := parseOSCARValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s on line %d: %s", name, line, err)
+			}
+
+			if name == "spo2" {
+				rec.Spo2 = v
+			} else {
+				rec.Pulse = v
+			}
+		}
+	}
+
+	result := make([]*Session, 0, len(sessions.order))
+	for _, id := range sessions.order {
+		records := sessions.records[id]
+		for _, rec := range records {
+			if rec.Spo2 == 0 || rec.Pulse == 0 || rec.Spo2 > 100 {
+				rec.Spo2, rec.Pulse = 0, 0
+				rec.Status = model.StatusFingerOut
+			}
+		}
+
+		s := newSession(records)
+		s.Source = OSCARFormat
+		result = append(result, s)
+	}
+
+	return result, nil
+}
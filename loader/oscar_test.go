@@ -0,0 +1,51 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package loader
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestOSCARLoader(t *testing.T) {
+	l, err := New(OSCARFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oscar := filepath.Join("testdata", "oscar-details.csv")
+	sessions := loadWith(t, l, oscar)
+	checkGolden(t, oscar, render(sessions))
+
+	// SleepyHead exports of the same sessions
+	sleepyhead := filepath.Join("testdata", "sleepyhead-details.csv")
+	if got, want := render(loadWith(t, l, sleepyhead)), render(sessions); !bytes.Equal(got, want) {
+		t.Errorf("Invalid sessions for %s: got\n%s\nshould be\n%s", sleepyhead, got, want)
+	}
+
+	_, err = l.Load(bytes.NewBufferString("date_time,session_id,pulse,spo2\n"))
+	if err == nil {
+		t.Errorf("Missing columns should fail")
+	}
+
+	_, err = l.Load(bytes.NewBufferString("DateTime,Session,Event,Data/Duration\n24/11/2018 23:00,1,SPO2,96\n"))
+	if err == nil {
+		t.Errorf("Invalid time should fail")
+	}
+}
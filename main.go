@@ -18,12 +18,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aebruno/myoxi/device"
+	"github.com/aebruno/myoxi/loader"
 	"github.com/aebruno/myoxi/model"
 	"github.com/aebruno/myoxi/tools"
 	log "github.com/sirupsen/logrus"
@@ -32,14 +39,67 @@ import (
 
 var (
 	MyoxiVersion = "dev"
+
+	// Serial traffic is logged here when --capture is set
+	captureWriter io.Writer
+
+	// Retry policy for idempotent device commands set by --retries
+	retryPolicy = device.DefaultRetryPolicy
 )
 
-func connectDevice(port string) (device.Device, error) {
-	log.Infof("Using device port: %s", port)
+// interruptContext returns a context that is cancelled on Ctrl-C
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sig:
+			log.Debug("Interrupted. Stopping")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sig)
+	}()
+
+	return ctx, cancel
+}
+
+func setRetryPolicy(dev device.Device) {
+	if retrier, ok := dev.(device.Retrier); ok {
+		retrier.SetRetryPolicy(retryPolicy)
+	}
+}
+
+func connectDevice(ctx context.Context, driver, port string) (device.Device, error) {
+	if port == "auto" {
+		return scanDevice(ctx, driver)
+	}
+
+	if strings.HasPrefix(port, device.SimulatorScheme) {
+		driver = "sim"
+	}
+
+	log.Infof("Using %s device port: %s", driver, port)
+
+	dev, err := device.New(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if captureWriter != nil {
+		if capturer, ok := dev.(device.Capturer); ok {
+			capturer.SetCapture(captureWriter)
+		} else {
+			log.Warnf("Device driver %s does not support capturing serial traffic", driver)
+		}
+	}
 
-	device := &device.CMS50{}
+	setRetryPolicy(dev)
 
-	err := device.Connect(port)
+	err = dev.Connect(ctx, port)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -51,6 +111,37 @@ func connectDevice(port string) (device.Device, error) {
 
 	log.Infof("Successfully connected to device at %s", port)
 
+	return dev, nil
+}
+
+func scanDevice(ctx context.Context, driver string) (device.Device, error) {
+	log.Infof("Scanning serial ports for %s device", driver)
+
+	ports, err := device.ListPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	device, port, err := device.Scan(ctx, driver, ports)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to find device port")
+
+		return nil, err
+	}
+
+	if captureWriter != nil {
+		// Reconnect so traffic to the device found is captured without
+		// the probes of other ports
+		device.Close()
+		return connectDevice(ctx, driver, port.Path)
+	}
+
+	log.Infof("Successfully connected to device at %s", port)
+
+	setRetryPolicy(device)
+
 	return device, nil
 }
 
@@ -93,13 +184,13 @@ func initDB(dbpath string) (model.Datastore, error) {
 	return db, nil
 }
 
-func setup(dbpath, port string) (model.Datastore, device.Device, error) {
+func setup(ctx context.Context, dbpath, driver, port string) (model.Datastore, device.Device, error) {
 	db, err := initDB(dbpath)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	device, err := connectDevice(port)
+	device, err := connectDevice(ctx, driver, port)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -107,7 +198,95 @@ func setup(dbpath, port string) (model.Datastore, device.Device, error) {
 	return db, device, nil
 }
 
-func main() {
+// rangeFlags returns the flags selecting records for the stats and export
+// commands. prefix starts each usage string.
+func rangeFlags(prefix string) []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{Name: "all, a", Usage: prefix + " all data"},
+		&cli.BoolFlag{Name: "prev, p", Usage: prefix + " previous session"},
+		&cli.BoolFlag{Name: "week, w", Usage: prefix + " last week"},
+		&cli.BoolFlag{Name: "month, m", Usage: prefix + " last month"},
+		&cli.BoolFlag{Name: "quarter, q", Usage: prefix + " last quarter"},
+		&cli.BoolFlag{Name: "year, y", Usage: prefix + " last year"},
+		&cli.Int64Flag{Name: "session, s", Usage: prefix + " session with this ID"},
+		&cli.StringFlag{Name: "from", Usage: prefix + " records from this date (2006-01-02, 2006-01-02 15:04 or RFC3339)"},
+		&cli.StringFlag{Name: "to", Usage: prefix + " records up to this date"},
+	}
+}
+
+// parseDate parses a date given on the command line in local time
+func parseDate(val string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		t, err := time.ParseInLocation(layout, val, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid date %q. Use 2006-01-02, 2006-01-02 15:04 or RFC3339", val)
+	}
+
+	return t, nil
+}
+
+// selectRecords returns the query for the records chosen with the range flags
+// and the sessions the records may belong to. Defaults to the latest session.
+func selectRecords(c *cli.Context, db model.Datastore) (*model.RecordQuery, []*model.Session, error) {
+	now := time.Now()
+	query := &model.RecordQuery{}
+
+	var session *model.Session
+	var err error
+	switch {
+	case c.Int64("session") > 0:
+		session, err = db.FetchSession(c.Int64("session"))
+	case len(c.String("from")) > 0 || len(c.String("to")) > 0:
+		if len(c.String("from")) > 0 {
+			query.From, err = parseDate(c.String("from"))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(c.String("to")) > 0 {
+			query.To, err = parseDate(c.String("to"))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	case c.Bool("all"):
+	case c.Bool("prev"):
+		session, err = db.FetchPreviousSession()
+	case c.Bool("week"):
+		query.From, query.To = now.Add(-24*7*time.Hour), now
+	case c.Bool("month"):
+		query.From, query.To = now.Add(-24*30*time.Hour), now
+	case c.Bool("quarter"):
+		query.From, query.To = now.Add(-24*91*time.Hour), now
+	case c.Bool("year"):
+		query.From, query.To = now.Add(-24*365*time.Hour), now
+	default:
+		session, err = db.FetchLatestSession()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if session != nil {
+		query.SessionID = session.ID
+		return query, []*model.Session{session}, nil
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return query, sessions, nil
+}
+
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "myoxi"
 	app.Authors = []cli.Author{cli.Author{Name: "Andrew E. Bruno", Email: "aeb@qnot.org"}}
@@ -115,8 +294,11 @@ func main() {
 	app.Version = MyoxiVersion
 	app.Flags = []cli.Flag{
 		&cli.BoolFlag{Name: "debug,d", Usage: "Print debug messages"},
-		&cli.StringFlag{Name: "port, p", Usage: "Path to device port", Value: "/dev/ttyUSB0"},
+		&cli.StringFlag{Name: "port, p", Usage: "Path or URL of device port (serial://, tcp://, rfc2217://, file://), auto to scan for the device or sim:// for a simulated device", Value: "/dev/ttyUSB0"},
+		&cli.StringFlag{Name: "device", Usage: "Device driver (see device list-drivers)", Value: "cms50f"},
 		&cli.StringFlag{Name: "dbpath, x", Usage: "Path to database file"},
+		&cli.StringFlag{Name: "capture", Usage: "Log all serial traffic to and from the device to file"},
+		&cli.IntFlag{Name: "retries", Usage: "Number of times to retry device commands that return bad data. Timeouts are only retried after an earlier attempt got a reply", Value: device.DefaultRetryPolicy.Attempts - 1},
 	}
 	app.Before = func(c *cli.Context) error {
 		if c.GlobalBool("debug") {
@@ -125,6 +307,30 @@ func main() {
 			log.SetLevel(log.InfoLevel)
 		}
 
+		retryPolicy = device.DefaultRetryPolicy
+		retryPolicy.Attempts = c.GlobalInt("retries") + 1
+
+		captureWriter = nil
+		if len(c.GlobalString("capture")) > 0 {
+			f, err := os.Create(c.GlobalString("capture"))
+			if err != nil {
+				return err
+			}
+			captureWriter = f
+			log.Infof("Capturing serial traffic to %s", c.GlobalString("capture"))
+		}
+
+		return nil
+	}
+	app.After = func(c *cli.Context) error {
+		if closer, ok := captureWriter.(io.Closer); ok {
+			err := closer.Close()
+			captureWriter = nil
+			if err != nil {
+				return fmt.Errorf("Failed to close capture file: %s", err)
+			}
+		}
+
 		return nil
 	}
 	app.Commands = []cli.Command{
@@ -134,88 +340,339 @@ func main() {
 			Flags: []cli.Flag{
 				&cli.BoolFlag{Name: "noop, n", Usage: "Dump data only. Don't save to database"},
 				&cli.BoolFlag{Name: "force, f", Usage: "Force overwrite session if exists"},
+				&cli.BoolFlag{Name: "erase-after", Usage: "Erase device memory after a successful import"},
+				&cli.IntSliceFlag{Name: "session, s", Usage: "Import only this device session numbered from 1 (see device command). May be repeated"},
+				&cli.BoolFlag{Name: "new-only", Usage: "Skip sessions that already exist in the database"},
+				&cli.BoolFlag{Name: "interactive, i", Usage: "Choose the sessions to import from a list"},
+				&cli.IntFlag{Name: "attempts", Usage: "Number of times to download a session before giving up", Value: 3},
+				&cli.BoolFlag{Name: "skip-verify", Usage: "Save sessions with fewer records than the session duration"},
+				&cli.BoolFlag{Name: "drift-correct", Usage: "Correct record times for device clock drift since the clock was last set with device set-time"},
+				&cli.StringFlag{Name: "tz", Usage: "Time zone the device clock was set to, as an IANA name (Europe/Paris) or UTC offset (+05:30). Defaults to local time"},
+				&cli.DurationFlag{Name: "max-clock-skew", Usage: "Warn if device clock differs from host by more than this (0 to disable)", Value: 2 * time.Minute},
 			},
 			Action: func(c *cli.Context) error {
-				db, device, err := setup(c.GlobalString("dbpath"), c.GlobalString("port"))
+				ctx, cancel := interruptContext()
+				defer cancel()
+
+				db, device, err := setup(ctx, c.GlobalString("dbpath"), c.GlobalString("device"), c.GlobalString("port"))
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
 
-				err = tools.Import(db, device, c.Bool("noop"), c.Bool("force"))
+				opts := &tools.ImportOptions{
+					Noop:             c.Bool("noop"),
+					Force:            c.Bool("force"),
+					EraseAfter:       c.Bool("erase-after"),
+					MaxClockSkew:     c.Duration("max-clock-skew"),
+					DownloadAttempts: c.Int("attempts"),
+					Sessions:         c.IntSlice("session"),
+					NewOnly:          c.Bool("new-only"),
+					Interactive:      c.Bool("interactive"),
+					SkipVerify:       c.Bool("skip-verify"),
+					TimeZone:         c.String("tz"),
+					DriftCorrect:     c.Bool("drift-correct"),
+				}
+
+				err = tools.Import(ctx, db, device, opts)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:      "import-file",
+			Usage:     "Import sessions from files exported by myoxi or other oximetry software",
+			ArgsUsage: "FILE...",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "format, f", Usage: "File format (" + strings.Join(loader.Formats(), ", ") + "). Defaults to the format for the file extension"},
+				&cli.BoolFlag{Name: "noop, n", Usage: "List sessions found only. Don't save to database"},
+				&cli.BoolFlag{Name: "force", Usage: "Overwrite sessions that duplicate a session in the database"},
+				&cli.BoolFlag{Name: "new-only", Usage: "Skip sessions that already exist in the database"},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError("Missing file to import", 1)
+				}
+
+				db, err := initDB(c.GlobalString("dbpath"))
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
 
+				opts := &tools.ImportFileOptions{
+					Format:  c.String("format"),
+					Noop:    c.Bool("noop"),
+					Force:   c.Bool("force"),
+					NewOnly: c.Bool("new-only"),
+				}
+
+				for _, path := range c.Args() {
+					err := tools.ImportFile(db, path, opts)
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+				}
+
 				return nil
 			},
 		},
 		{
 			Name:  "stats",
 			Usage: "Display database stats",
-			Flags: []cli.Flag{
-				&cli.BoolFlag{Name: "all, a", Usage: "Display stats for all data"},
-				&cli.BoolFlag{Name: "prev, p", Usage: "Display stats for previous session"},
-				&cli.BoolFlag{Name: "week, w", Usage: "Display stats for last week"},
-				&cli.BoolFlag{Name: "month, m", Usage: "Display stats for last month"},
-				&cli.BoolFlag{Name: "quarter, q", Usage: "Display stats for last quarter"},
-				&cli.BoolFlag{Name: "year, y", Usage: "Display stats for last year"},
+			Flags: rangeFlags("Display stats for"),
+			Action: func(c *cli.Context) error {
+				db, err := initDB(c.GlobalString("dbpath"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				query, sessions, err := selectRecords(c, db)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				records := make([]*model.OxiRecord, 0)
+				err = db.StreamRecords(query, func(rec *model.OxiRecord) error {
+					records = append(records, rec)
+					return nil
+				})
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				if len(records) == 0 {
+					return cli.NewExitError("No records found", 1)
+				}
+
+				tools.ComputeAndPrintStats(records, sessions)
+
+				return nil
 			},
+		},
+		{
+			Name:  "export",
+			Usage: "Export records as CSV, TSV or JSON",
+			Flags: append(rangeFlags("Export"),
+				&cli.StringFlag{Name: "format, f", Usage: "Output format (csv, tsv, json or ndjson)", Value: tools.ExportCSV},
+				&cli.StringFlag{Name: "output, o", Usage: "Path to output file. Defaults to stdout"},
+			),
 			Action: func(c *cli.Context) error {
 				db, err := initDB(c.GlobalString("dbpath"))
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
 
-				now := time.Now()
-				var records []*model.OxiRecord
-				if c.Bool("all") {
-					records, err = db.FetchRecords(time.Time{}, time.Time{})
-				} else if c.Bool("prev") {
-					session, err := db.FetchPreviousSession()
+				err = tools.CheckExportFormat(c.String("format"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				query, _, err := selectRecords(c, db)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				if len(c.String("output")) == 0 {
+					err = tools.Export(db, query, c.String("format"), os.Stdout)
 					if err != nil {
 						return cli.NewExitError(err, 1)
 					}
-					records, err = db.FetchRecordsBySessionID(session.ID)
-				} else if c.Bool("week") {
-					records, err = db.FetchRecords(now.Add(-24*7*time.Hour), now)
-				} else if c.Bool("month") {
-					records, err = db.FetchRecords(now.Add(-24*30*time.Hour), now)
-				} else if c.Bool("year") {
-					records, err = db.FetchRecords(now.Add(-24*365*time.Hour), now)
+					return nil
+				}
+
+				out, err := os.Create(c.String("output"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				err = tools.Export(db, query, c.String("format"), out)
+				cerr := out.Close()
+				if err == nil && cerr != nil {
+					err = fmt.Errorf("Failed to close %s: %s", c.String("output"), cerr)
+				}
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "live",
+			Usage: "Stream live data from device",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "record, r", Usage: "Record live data to a new session in the database"},
+				&cli.BoolFlag{Name: "waveform, w", Usage: "Also record the plethysmograph waveform (requires --record)"},
+			},
+			Action: func(c *cli.Context) error {
+				if c.Bool("waveform") && !c.Bool("record") {
+					return cli.NewExitError("The --waveform option requires --record", 1)
+				}
+
+				ctx, cancel := interruptContext()
+				defer cancel()
+
+				var db model.Datastore
+				var device device.Device
+				var err error
+				if c.Bool("record") {
+					db, device, err = setup(ctx, c.GlobalString("dbpath"), c.GlobalString("device"), c.GlobalString("port"))
 				} else {
+					device, err = connectDevice(ctx, c.GlobalString("device"), c.GlobalString("port"))
+				}
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				err = tools.Live(ctx, db, device, c.Bool("record"), c.Bool("waveform"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "waveform",
+			Usage: "Export recorded plethysmograph waveform",
+			Flags: []cli.Flag{
+				&cli.Int64Flag{Name: "session, s", Usage: "Session ID to export. Defaults to latest session"},
+				&cli.StringFlag{Name: "output, o", Usage: "Path to output file. Defaults to stdout"},
+			},
+			Action: func(c *cli.Context) error {
+				db, err := initDB(c.GlobalString("dbpath"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				sessionID := c.Int64("session")
+				if sessionID == 0 {
 					session, err := db.FetchLatestSession()
 					if err != nil {
 						return cli.NewExitError(err, 1)
 					}
-					records, err = db.FetchRecordsBySessionID(session.ID)
+					sessionID = session.ID
 				}
 
+				out := os.Stdout
+				if len(c.String("output")) > 0 {
+					out, err = os.Create(c.String("output"))
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+					defer out.Close()
+				}
+
+				err = tools.ExportWaveform(db, sessionID, out)
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
 
-				tools.ComputeAndPrintStats(records)
-
 				return nil
 			},
 		},
 		{
 			Name:  "device",
 			Usage: "Display information about device",
+			Flags: []cli.Flag{
+				&cli.DurationFlag{Name: "max-clock-skew", Usage: "Warn if device clock differs from host by more than this (0 to disable)", Value: 2 * time.Minute},
+			},
 			Action: func(c *cli.Context) error {
-				device, err := connectDevice(c.GlobalString("port"))
+				ctx, cancel := interruptContext()
+				defer cancel()
+
+				device, err := connectDevice(ctx, c.GlobalString("device"), c.GlobalString("port"))
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
 
-				err = tools.DeviceInfo(device)
+				err = tools.DeviceInfo(ctx, device, c.Duration("max-clock-skew"))
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
 
 				return nil
 			},
+			Subcommands: []cli.Command{
+				{
+					Name:  "erase",
+					Usage: "Erase sessions from device memory",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{Name: "force, f", Usage: "Erase even if sessions have not been imported"},
+						&cli.StringFlag{Name: "tz", Usage: "Time zone the device clock was set to, as given to import --tz. Defaults to local time"},
+					},
+					Action: func(c *cli.Context) error {
+						ctx, cancel := interruptContext()
+						defer cancel()
+
+						db, device, err := setup(ctx, c.GlobalString("dbpath"), c.GlobalString("device"), c.GlobalString("port"))
+						if err != nil {
+							return cli.NewExitError(err, 1)
+						}
+
+						err = tools.Erase(ctx, db, device, c.Bool("force"), c.String("tz"))
+						if err != nil {
+							return cli.NewExitError(err, 1)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:  "scan",
+					Usage: "Scan serial ports for devices",
+					Action: func(c *cli.Context) error {
+						ctx, cancel := interruptContext()
+						defer cancel()
+
+						err := tools.ScanPorts(ctx, c.GlobalString("device"))
+						if err != nil {
+							return cli.NewExitError(err, 1)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:  "list-drivers",
+					Usage: "List available device drivers",
+					Action: func(c *cli.Context) error {
+						for _, name := range device.Drivers() {
+							fmt.Println(name)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:  "set-time",
+					Usage: "Set the device clock to the host local time",
+					Action: func(c *cli.Context) error {
+						ctx, cancel := interruptContext()
+						defer cancel()
+
+						db, device, err := setup(ctx, c.GlobalString("dbpath"), c.GlobalString("device"), c.GlobalString("port"))
+						if err != nil {
+							return cli.NewExitError(err, 1)
+						}
+
+						err = tools.SetClock(ctx, db, device)
+						if err != nil {
+							return cli.NewExitError(err, 1)
+						}
+
+						return nil
+					},
+				},
+			},
 		}}
 
+	return app
+}
+
+func main() {
+	app := newApp()
 	app.RunAndExitOnError()
 }
@@ -0,0 +1,101 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestCLI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "myoxi-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Don't exit the test on command errors
+	exiter := cli.OsExiter
+	cli.OsExiter = func(code int) {}
+	defer func() { cli.OsExiter = exiter }()
+
+	dbpath := filepath.Join(dir, "myoxi.db")
+	port := "sim://?sessions=2&duration=3h&seed=11"
+
+	commands := [][]string{
+		{"device"},
+		{"import"},
+		{"import", "--force", "--erase-after"},
+		{"stats"},
+		{"stats", "--prev"},
+		{"stats", "--all"},
+		{"stats", "--session", "1"},
+		{"stats", "--from", "2000-01-01"},
+		{"export", "--format", "json", "--output", filepath.Join(dir, "export.json")},
+		{"export", "--all", "--format", "tsv", "--output", filepath.Join(dir, "export.tsv")},
+		{"import-file", "--new-only", filepath.Join(dir, "export.tsv")},
+		{"device", "list-drivers"},
+	}
+
+	for _, args := range commands {
+		app := newApp()
+		err := app.Run(append([]string{"myoxi", "--dbpath", dbpath, "--port", port}, args...))
+		if err != nil {
+			t.Errorf("Command %v failed: %s", args, err)
+		}
+	}
+
+	app := newApp()
+	err = app.Run([]string{"myoxi", "--dbpath", dbpath, "--port", port, "import"})
+	if err == nil {
+		t.Errorf("Expected error importing sessions that already exist")
+	}
+
+	// A bad format leaves no output file behind
+	badOutput := filepath.Join(dir, "bad.csv")
+	app = newApp()
+	err = app.Run([]string{"myoxi", "--dbpath", dbpath, "export", "--format", "xml", "--output", badOutput})
+	if err == nil {
+		t.Errorf("Expected error for unknown export format")
+	}
+
+	if _, err := os.Stat(badOutput); !os.IsNotExist(err) {
+		t.Errorf("Output file created for unknown export format: %v", err)
+	}
+
+	capture := filepath.Join(dir, "capture.log")
+	app = newApp()
+	err = app.Run([]string{"myoxi", "--dbpath", dbpath, "--port", port, "--capture", capture, "device"})
+	if err != nil {
+		t.Errorf("Command with --capture failed: %s", err)
+	}
+
+	if captureWriter != nil {
+		t.Errorf("Capture file not closed after command")
+	}
+
+	app = newApp()
+	err = app.Run([]string{"myoxi", "--dbpath", dbpath, "--port", port, "live", "--waveform"})
+	if err == nil {
+		t.Errorf("Expected error for live --waveform without --record")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ClockSet records a time the device clock was set to the host clock
+type ClockSet struct {
+	ID       int64     `db:"id" json:"id"`
+	DeviceID string    `db:"device_id" json:"device_id"`
+	SetTime  time.Time `db:"set_time" json:"set_time"`
+
+	// Seconds the device clock was ahead of the host clock before it was set
+	Skew int `db:"skew_seconds" json:"skew_seconds"`
+}
+
+func (c *ClockSet) String() string {
+	return fmt.Sprintf("DeviceID=%s SetTime=%s Skew=%s", c.DeviceID, c.SetTime.Local().Format("2006-01-02 15:04:05"), time.Duration(c.Skew)*time.Second)
+}
+
+func (db *DB) SaveClockSet(clock *ClockSet) error {
+	row := *clock
+	row.SetTime = row.SetTime.UTC()
+
+	res, err := db.NamedExec(`
+        insert into clock_set (device_id, set_time, skew_seconds) 
+        values (:device_id, :set_time, :skew_seconds)`, &row)
+	if err != nil {
+		return err
+	}
+
+	clock.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FetchLastClockSet returns the last time the clock of the device was set
+func (db *DB) FetchLastClockSet(deviceID string) (*ClockSet, error) {
+	query := `
+        select
+            id,
+            device_id,
+            set_time,
+            skew_seconds
+        from clock_set
+        where device_id = ?
+        order by set_time desc
+        limit 1
+	`
+
+	log.Debugf("Fetch Last Clock Set query: %s", query)
+
+	clock := &ClockSet{}
+	err := db.Get(clock, query, deviceID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return clock, nil
+}
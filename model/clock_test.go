@@ -0,0 +1,58 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockSet(t *testing.T) {
+	db, err := newTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.FetchLastClockSet("0154321")
+	if err != ErrNotFound {
+		t.Errorf("Expected not found for device clock never set: got '%v'", err)
+	}
+
+	start := time.Now()
+	data := []*ClockSet{
+		&ClockSet{DeviceID: "0154321", SetTime: start.Add(-48 * time.Hour), Skew: 30},
+		&ClockSet{DeviceID: "0154321", SetTime: start, Skew: -90},
+		&ClockSet{DeviceID: "0999999", SetTime: start.Add(time.Hour)},
+	}
+
+	for _, c := range data {
+		err := db.SaveClockSet(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock, err := db.FetchLastClockSet("0154321")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clock.ID != data[1].ID || !clock.SetTime.Equal(data[1].SetTime) || clock.Skew != -90 {
+		t.Errorf("Invalid last clock set: got '%s' should be '%s'", clock, data[1])
+	}
+}
@@ -19,36 +19,110 @@ package model
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
 	SessionSchema = `
 		create table if not exists session 
-		(id integer primary key, start_time datetime unique, model string, duration_seconds integer)
+		(id integer primary key, start_time datetime unique, model string, duration_seconds integer,
+		 vendor text not null default '', device_id text not null default '', device_info text not null default '',
+		 sample_interval integer not null default 1, time_zone text not null default '',
+		 clock_drift integer not null default 0, drift_correction integer not null default 0,
+		 source text not null default '')
 	`
 
 	OxiRecordSchema = `
 		create table if not exists oxi_record 
-		(date_time datetime primary key, session_id integer not null, pulse integer, spo2 integer)
+		(date_time datetime not null, session_id integer not null, pulse integer, spo2 integer,
+		 status integer not null default 0, motion integer not null default 0, primary key (session_id, date_time))
+	`
+
+	OxiRecordIndex = `
+		create index if not exists oxi_record_date_time on oxi_record (date_time)
+	`
+
+	WaveformSchema = `
+		create table if not exists waveform 
+		(date_time datetime not null, session_id integer not null, pleth integer, primary key (session_id, date_time))
+	`
+
+	ClockSetSchema = `
+		create table if not exists clock_set 
+		(id integer primary key, device_id text not null, set_time datetime not null, skew_seconds integer not null default 0)
 	`
 )
 
 var ErrNotFound = errors.New("Record not found in database")
 
+// Columns added to existing tables since the initial release. Databases
+// created by older versions are migrated on Initialize. The optional update
+// fills in the new column for existing rows.
+var migrations = []struct {
+	table      string
+	column     string
+	definition string
+	update     string
+}{
+	{"session", "vendor", "text not null default ''", ""},
+	{"session", "device_id", "text not null default ''", ""},
+	{"session", "device_info", "text not null default ''", ""},
+	{"session", "sample_interval", "integer not null default 1", ""},
+	{"session", "time_zone", "text not null default ''", fmt.Sprintf("update session set time_zone = '%s'", strings.Replace(LocalZoneName(), "'", "''", -1))},
+	{"session", "clock_drift", "integer not null default 0", ""},
+	{"session", "drift_correction", "integer not null default 0", ""},
+	{"session", "source", "text not null default ''", ""},
+	{"oxi_record", "status", "integer not null default 0", fmt.Sprintf("update oxi_record set status = %d where pulse = 0 and spo2 = 0", StatusFingerOut)},
+	{"oxi_record", "motion", "integer not null default 0", ""},
+}
+
+// Time columns are compared as text so times are stored in UTC. Older
+// versions stored times with the local UTC offset and are rewritten once on
+// Initialize. Rows of tables keyed on time that become duplicates of another
+// row once in UTC are replaced.
+var utcColumns = []struct {
+	table   string
+	column  string
+	replace bool
+}{
+	{"session", "start_time", false},
+	{"oxi_record", "date_time", true},
+	{"waveform", "date_time", true},
+	{"clock_set", "set_time", false},
+}
+
+// Database versions stored in pragma user_version. Migrations that rewrite
+// whole tables run once when the database is older than their version.
+const (
+	versionRecordKey = 1
+	versionUTC       = 2
+)
+
 type Datastore interface {
 	Initialize() error
 	SaveRecords(records []*OxiRecord) error
 	FetchRecords(from, to time.Time) ([]*OxiRecord, error)
 	FetchRecordsBySessionID(id int64) ([]*OxiRecord, error)
+	StreamRecords(query *RecordQuery, handler func(rec *OxiRecord) error) error
+	CountRecordsBySessionID(id int64) (int, error)
 	SaveSession(session *Session) error
+	UpdateSession(session *Session) error
 	FetchLatestSession() (*Session, error)
 	FetchPreviousSession() (*Session, error)
+	FetchSession(id int64) (*Session, error)
 	FetchSessionByStartTime(start time.Time) (*Session, error)
 	FetchAllSessions() ([]*Session, error)
+	SaveWaveform(samples []*WaveformSample) error
+	FetchWaveformBySessionID(id int64) ([]*WaveformSample, error)
+	SaveClockSet(clock *ClockSet) error
+	FetchLastClockSet(deviceID string) (*ClockSet, error)
+	BeginImport() (ImportTx, error)
 }
 
 type DB struct {
@@ -80,5 +154,224 @@ func (db *DB) Initialize() error {
 		return err
 	}
 
+	_, err = db.Exec(WaveformSchema)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ClockSetSchema)
+	if err != nil {
+		return err
+	}
+
+	return db.migrate()
+}
+
+func (db *DB) migrate() error {
+	for _, m := range migrations {
+		exists, err := db.hasColumn(m.table, m.column)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			continue
+		}
+
+		log.Infof("Migrating database: adding column %s to table %s", m.column, m.table)
+		_, err = db.Exec(fmt.Sprintf("alter table %s add column %s %s", m.table, m.column, m.definition))
+		if err != nil {
+			return err
+		}
+
+		if len(m.update) > 0 {
+			_, err = db.Exec(m.update)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	version := 0
+	err := db.Get(&version, "pragma user_version")
+	if err != nil {
+		return err
+	}
+
+	if version < versionRecordKey {
+		err = db.migrateRecordKey()
+		if err != nil {
+			return err
+		}
+
+		err = db.setVersion(versionRecordKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(OxiRecordIndex)
+	if err != nil {
+		return err
+	}
+
+	if version < versionUTC {
+		err = db.migrateUTC()
+		if err != nil {
+			return err
+		}
+
+		err = db.setVersion(versionUTC)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+func (db *DB) setVersion(version int) error {
+	_, err := db.Exec(fmt.Sprintf("pragma user_version = %d", version))
+	return err
+}
+
+// migrateRecordKey rebuilds oxi_record tables created by older versions that
+// are keyed on date_time alone. Records of sessions that overlap in time, such
+// as two devices worn the same night, would replace each other.
+func (db *DB) migrateRecordKey() error {
+	keyed, err := db.isPrimaryKey("oxi_record", "session_id")
+	if err != nil || keyed {
+		return err
+	}
+
+	log.Info("Migrating database: adding session_id to the primary key of table oxi_record")
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		`alter table oxi_record rename to oxi_record_old`,
+		OxiRecordSchema,
+		`insert into oxi_record (date_time, session_id, pulse, spo2, status, motion)
+		 select date_time, session_id, pulse, spo2, status, motion from oxi_record_old`,
+		`drop table oxi_record_old`,
+	} {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to migrate oxi_record primary key: %s", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// migrateUTC rewrites times stored with a UTC offset other than zero in UTC
+func (db *DB) migrateUTC() error {
+	for _, c := range utcColumns {
+		rows, err := db.Queryx(fmt.Sprintf("select rowid, %s from %s where %s not like '%%+00:00'", c.column, c.table, c.column))
+		if err != nil {
+			return err
+		}
+
+		ids := make([]int64, 0)
+		times := make([]time.Time, 0)
+		for rows.Next() {
+			var id int64
+			var t time.Time
+			err := rows.Scan(&id, &t)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			times = append(times, t)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(ids) == 0 {
+			continue
+		}
+
+		log.Infof("Migrating database: storing %d times in column %s of table %s in UTC", len(ids), c.column, c.table)
+
+		update := "update"
+		if c.replace {
+			update = "update or replace"
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return err
+		}
+
+		for i := range ids {
+			_, err := tx.Exec(fmt.Sprintf("%s %s set %s = ? where rowid = ?", update, c.table, c.column), times[i].UTC(), ids[i])
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("Failed to store %s.%s in UTC: %s", c.table, c.column, err)
+			}
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	info, err := db.columnInfo(table, column)
+	return info != nil, err
+}
+
+// isPrimaryKey returns true if column is part of the primary key of table
+func (db *DB) isPrimaryKey(table, column string) (bool, error) {
+	info, err := db.columnInfo(table, column)
+	if err != nil || info == nil {
+		return false, err
+	}
+
+	pk, _ := info["pk"].(int64)
+	return pk > 0, nil
+}
+
+// columnInfo returns the table_info pragma row for column or nil if table has
+// no such column
+func (db *DB) columnInfo(table, column string) (map[string]interface{}, error) {
+	rows, err := db.Queryx(fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		info := make(map[string]interface{})
+		err := rows.MapScan(info)
+		if err != nil {
+			return nil, err
+		}
+
+		var name string
+		switch v := info["name"].(type) {
+		case string:
+			name = v
+		case []byte:
+			name = string(v)
+		}
+
+		if name == column {
+			return info, nil
+		}
+	}
+
+	return nil, rows.Err()
+}
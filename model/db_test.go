@@ -19,6 +19,7 @@ package model
 
 import (
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -47,3 +48,194 @@ func TestDB(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMigrate(t *testing.T) {
+	db, err := NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Schema from the initial release
+	_, err = db.(*DB).Exec(`
+		create table session 
+		(id integer primary key, start_time datetime unique, model string, duration_seconds integer)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.(*DB).Exec(`insert into session (start_time, model, duration_seconds) values (?, ?, ?)`, time.Now(), "50F", 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.(*DB).Exec(`
+		create table oxi_record 
+		(date_time datetime primary key, session_id integer not null, pulse integer, spo2 integer)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i, v := range []uint8{0, 97} {
+		_, err = db.(*DB).Exec(`insert into oxi_record (date_time, session_id, pulse, spo2) values (?, ?, ?, ?)`, start.Add(time.Duration(i)*time.Second), 1, v, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = db.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range migrations {
+		exists, err := db.(*DB).hasColumn(m.table, m.column)
+		if err != nil {
+			t.Error(err)
+		}
+		if !exists {
+			t.Errorf("Column %s missing from table %s after migration", m.column, m.table)
+		}
+	}
+
+	session, err := db.FetchLatestSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.Model != "50F" || session.Seconds != 3600 {
+		t.Errorf("Invalid session after migration: %s", session)
+	}
+
+	if session.TimeZone != LocalZoneName() {
+		t.Errorf("Invalid session time zone after migration: got '%s' should be '%s'", session.TimeZone, LocalZoneName())
+	}
+
+	records, err := db.FetchRecordsBySessionID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || records[0].Status != StatusFingerOut || records[1].Status != StatusValid {
+		t.Errorf("Invalid record status after migration: %v", records)
+	}
+
+	version := 0
+	err = db.(*DB).Get(&version, "pragma user_version")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != versionUTC {
+		t.Errorf("Invalid database version after migration: got '%d' should be '%d'", version, versionUTC)
+	}
+
+	pk, err := db.(*DB).isPrimaryKey("oxi_record", "session_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pk {
+		t.Errorf("oxi_record not keyed on session_id after migration")
+	}
+
+	// Records from different sessions may share a date_time
+	err = db.SaveRecords([]*OxiRecord{&OxiRecord{DateTime: start, SessionID: 2, Pulse: 61, Spo2: 96}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int64{1, 2} {
+		count, err := db.CountRecordsBySessionID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 2
+		if id == 2 {
+			want = 1
+		}
+		if count != want {
+			t.Errorf("Invalid record count for session %d: got '%d' should be '%d'", id, count, want)
+		}
+	}
+
+	// Running again should be a no-op
+	err = db.Initialize()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateUTC(t *testing.T) {
+	db, err := newTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	newYork := time.FixedZone("EST", -5*60*60)
+	start := time.Date(2018, time.November, 24, 14, 0, 0, 0, time.UTC)
+
+	// Database from before times were stored in UTC
+	err = db.(*DB).setVersion(versionRecordKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows written by older versions with the local UTC offset. The first two
+	// records are the same sample.
+	_, err = db.(*DB).Exec(`insert into session (start_time, model, duration_seconds) values (?, ?, ?)`, start.In(tokyo), "50F", 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ts := range []time.Time{start.In(tokyo), start.In(newYork), start.Add(time.Hour).In(newYork)} {
+		_, err = db.(*DB).Exec(`insert into oxi_record (date_time, session_id, pulse, spo2) values (?, ?, ?, ?)`, ts, 1, 60, 97)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = db.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	err = db.(*DB).Get(&stored, `select cast(start_time as text) from session`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored != "2018-11-24 14:00:00+00:00" {
+		t.Errorf("Invalid session start time after migration: got '%s' should be '%s'", stored, "2018-11-24 14:00:00+00:00")
+	}
+
+	records, err := db.FetchRecords(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || !records[0].DateTime.Equal(start) || !records[1].DateTime.Equal(start.Add(time.Hour)) {
+		t.Errorf("Invalid records after migration: %v", records)
+	}
+
+	// The migration only runs once
+	_, err = db.(*DB).Exec(`update session set start_time = ?`, start.In(tokyo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.(*DB).Get(&stored, `select cast(start_time as text) from session`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored != "2018-11-24 23:00:00+09:00" {
+		t.Errorf("Invalid session start time after second initialize: got '%s' should be '%s'", stored, "2018-11-24 23:00:00+09:00")
+	}
+}
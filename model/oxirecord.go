@@ -19,20 +19,83 @@ package model
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 )
 
+// Status flags the quality of a sample. Pulse and SpO2 values of flagged
+// samples should not be used for analysis.
+type Status uint8
+
+const (
+	// No finger in the probe
+	StatusFingerOut Status = 1 << iota
+
+	// Probe disconnected or faulty
+	StatusProbeError
+
+	// Device is searching for a pulse
+	StatusSearching
+
+	// Signal too weak for a reliable reading
+	StatusLowPerfusion
+
+	// Sample is valid when no flags are set
+	StatusValid Status = 0
+)
+
+var statusNames = []struct {
+	flag Status
+	name string
+}{
+	{StatusFingerOut, "finger-out"},
+	{StatusProbeError, "probe-error"},
+	{StatusSearching, "searching"},
+	{StatusLowPerfusion, "low-perfusion"},
+}
+
+// Has returns true if flag is set
+func (s Status) Has(flag Status) bool {
+	return s&flag != 0
+}
+
+func (s Status) String() string {
+	if s == StatusValid {
+		return "valid"
+	}
+
+	names := make([]string, 0, len(statusNames))
+	for _, sn := range statusNames {
+		if s.Has(sn.flag) {
+			names = append(names, sn.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
 type OxiRecord struct {
 	DateTime  time.Time `db:"date_time" json:"date_time"`
 	SessionID int64     `db:"session_id" json:"session_id"`
 	Pulse     uint8     `db:"pulse" json:"pulse"`
 	Spo2      uint8     `db:"spo2" json:"spo2"`
+	Status    Status    `db:"status" json:"status"`
+
+	// Movement of the wearer during the sample on a 0-255 scale. Only
+	// recorded by devices with a motion sensor
+	Motion uint8 `db:"motion" json:"motion,omitempty"`
+}
+
+// Valid returns true if the record has no quality flags set
+func (r *OxiRecord) Valid() bool {
+	return r.Status == StatusValid
 }
 
 func (r *OxiRecord) String() string {
-	return fmt.Sprintf("DateTime=%s Pulse=%d SPO2=%d", r.DateTime.Format("2006-01-02 15:04:05"), r.Pulse, r.Spo2)
+	return fmt.Sprintf("DateTime=%s Pulse=%d SPO2=%d Status=%s", r.DateTime.Format("2006-01-02 15:04:05"), r.Pulse, r.Spo2, r.Status)
 }
 
 func (db *DB) SaveRecords(records []*OxiRecord) error {
@@ -40,12 +103,24 @@ func (db *DB) SaveRecords(records []*OxiRecord) error {
 	if err != nil {
 		return err
 	}
-	defer tx.Commit()
 
+	err = saveRecords(tx, records)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func saveRecords(ext sqlx.Ext, records []*OxiRecord) error {
 	for _, record := range records {
-		_, err := tx.NamedExec(`
-            replace into oxi_record (date_time, session_id, pulse, spo2) 
-            values (:date_time, :session_id, :pulse, :spo2)`, record)
+		row := *record
+		row.DateTime = row.DateTime.UTC()
+
+		_, err := sqlx.NamedExec(ext, `
+            replace into oxi_record (date_time, session_id, pulse, spo2, status, motion) 
+            values (:date_time, :session_id, :pulse, :spo2, :status, :motion)`, &row)
 		if err != nil {
 			return err
 		}
@@ -61,7 +136,9 @@ func (db *DB) FetchRecords(from, to time.Time) ([]*OxiRecord, error) {
 			date_time,
             session_id,
 			pulse,
-            spo2
+            spo2,
+            status,
+            motion
         from oxi_record
 	`
 
@@ -69,14 +146,14 @@ func (db *DB) FetchRecords(from, to time.Time) ([]*OxiRecord, error) {
 
 	if from != nullTime && to != nullTime {
 		query += ` where date_time > ? and date_time < ?`
-		args = append(args, from)
-		args = append(args, to)
+		args = append(args, from.UTC())
+		args = append(args, to.UTC())
 	} else if from != nullTime {
 		query += ` where date_time > ?`
-		args = append(args, from)
+		args = append(args, from.UTC())
 	} else if to != nullTime {
 		query += ` where date_time < ?`
-		args = append(args, to)
+		args = append(args, to.UTC())
 	}
 
 	query += ` order by date_time asc`
@@ -92,15 +169,84 @@ func (db *DB) FetchRecords(from, to time.Time) ([]*OxiRecord, error) {
 	return data, nil
 }
 
+// RecordQuery selects records by session or time range. Zero values are
+// ignored and an empty query selects all records.
+type RecordQuery struct {
+	SessionID int64
+	From      time.Time
+	To        time.Time
+}
+
+// StreamRecords calls handler for each record selected by query in time order
+// without loading all records into memory
+func (db *DB) StreamRecords(query *RecordQuery, handler func(rec *OxiRecord) error) error {
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if query.SessionID != 0 {
+		where = append(where, "session_id = ?")
+		args = append(args, query.SessionID)
+	}
+	if !query.From.IsZero() {
+		where = append(where, "date_time > ?")
+		args = append(args, query.From.UTC())
+	}
+	if !query.To.IsZero() {
+		where = append(where, "date_time < ?")
+		args = append(args, query.To.UTC())
+	}
+
+	sql := `
+        select
+			date_time,
+            session_id,
+			pulse,
+            spo2,
+            status,
+            motion
+        from oxi_record
+	`
+	if len(where) > 0 {
+		sql += ` where ` + strings.Join(where, " and ")
+	}
+	sql += ` order by date_time asc`
+
+	log.Debugf("Stream Records args: %v query: %s", args, sql)
+
+	rows, err := db.Queryx(sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := &OxiRecord{}
+		err := rows.StructScan(rec)
+		if err != nil {
+			return err
+		}
+
+		err = handler(rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 func (db *DB) FetchRecordsBySessionID(sessionID int64) ([]*OxiRecord, error) {
 	query := `
         select
 			date_time,
             session_id,
 			pulse,
-            spo2
+            spo2,
+            status,
+            motion
         from oxi_record
         where session_id = ?
+        order by date_time asc
 	`
 
 	log.Debugf("Fetch Records by session id query: %s", query)
@@ -113,3 +259,17 @@ func (db *DB) FetchRecordsBySessionID(sessionID int64) ([]*OxiRecord, error) {
 
 	return data, nil
 }
+
+func (db *DB) CountRecordsBySessionID(sessionID int64) (int, error) {
+	query := `select count(*) from oxi_record where session_id = ?`
+
+	log.Debugf("Count Records by session id query: %s", query)
+
+	count := 0
+	err := db.Get(&count, query, sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
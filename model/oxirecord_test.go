@@ -36,7 +36,9 @@ func TestOxiRecord(t *testing.T) {
 		&OxiRecord{DateTime: start.Add(time.Second * 3), Pulse: 76, Spo2: 95, SessionID: 1},
 		&OxiRecord{DateTime: start.Add(time.Second * 4), Pulse: 79, Spo2: 98, SessionID: 1},
 		&OxiRecord{DateTime: start.Add(time.Second * 5), Pulse: 77, Spo2: 99, SessionID: 1},
-		&OxiRecord{DateTime: start.Add(time.Second * 6), Pulse: 79, Spo2: 94, SessionID: 1},
+		&OxiRecord{DateTime: start.Add(time.Second * 6), Pulse: 79, Spo2: 94, SessionID: 1, Motion: 42},
+		&OxiRecord{DateTime: start.Add(time.Second * 7), SessionID: 1, Status: StatusFingerOut},
+		&OxiRecord{DateTime: start.Add(time.Second * 8), Pulse: 80, Spo2: 93, SessionID: 1, Status: StatusSearching | StatusLowPerfusion},
 	}
 
 	err = db.SaveRecords(data)
@@ -46,7 +48,7 @@ func TestOxiRecord(t *testing.T) {
 
 	queries := [][]time.Time{
 		[]time.Time{time.Time{}, time.Time{}},
-		[]time.Time{start, start.Add(time.Second * 7)},
+		[]time.Time{start, start.Add(time.Second * 9)},
 	}
 
 	for _, query := range queries {
@@ -63,12 +65,18 @@ func TestOxiRecord(t *testing.T) {
 			if records[i].DateTime.UTC() != data[i].DateTime.UTC() {
 				t.Errorf("Invalid datetime for record %d. Got %s wanted %s", i, records[i].DateTime.UTC(), data[i].DateTime.UTC())
 			}
+			if records[i].Status != data[i].Status {
+				t.Errorf("Invalid status for record %d. Got %s wanted %s", i, records[i].Status, data[i].Status)
+			}
 			if records[i].Pulse != data[i].Pulse {
 				t.Errorf("Invalid pulse for record %d. Got %d wanted %d", i, records[i].Pulse, data[i].Pulse)
 			}
 			if records[i].Spo2 != data[i].Spo2 {
 				t.Errorf("Invalid spo2 for record %d. Got %d wanted %d", i, records[i].Spo2, data[i].Spo2)
 			}
+			if records[i].Motion != data[i].Motion {
+				t.Errorf("Invalid motion for record %d. Got %d wanted %d", i, records[i].Motion, data[i].Motion)
+			}
 		}
 	}
 
@@ -98,4 +106,121 @@ func TestOxiRecord(t *testing.T) {
 	if len(records) != len(data) {
 		t.Errorf("Invalid number of records returned for fetch by sessionID. Got %d wanted %d", len(records), len(data))
 	}
+
+	count, err := db.CountRecordsBySessionID(1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if count != len(data) {
+		t.Errorf("Invalid number of records counted for sessionID. Got %d wanted %d", count, len(data))
+	}
+
+	streamQueries := []struct {
+		query *RecordQuery
+		count int
+	}{
+		{&RecordQuery{}, len(data)},
+		{&RecordQuery{SessionID: 1}, len(data)},
+		{&RecordQuery{SessionID: 2}, 0},
+		{&RecordQuery{From: start.Add(time.Second * 4)}, 4},
+		{&RecordQuery{SessionID: 1, From: start, To: start.Add(time.Second * 3)}, 2},
+	}
+
+	for _, test := range streamQueries {
+		streamed := make([]*OxiRecord, 0)
+		err := db.StreamRecords(test.query, func(rec *OxiRecord) error {
+			streamed = append(streamed, rec)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+
+		if len(streamed) != test.count {
+			t.Errorf("Invalid number of records streamed for %+v. Got %d wanted %d", test.query, len(streamed), test.count)
+		}
+
+		for i := 1; i < len(streamed); i++ {
+			if streamed[i].DateTime.Before(streamed[i-1].DateTime) {
+				t.Errorf("Streamed records out of order: %s before %s", streamed[i-1], streamed[i])
+			}
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		status Status
+		valid  string
+	}{
+		{StatusValid, "valid"},
+		{StatusFingerOut, "finger-out"},
+		{StatusProbeError | StatusSearching, "probe-error,searching"},
+		{StatusLowPerfusion, "low-perfusion"},
+	}
+
+	for _, test := range tests {
+		if test.status.String() != test.valid {
+			t.Errorf("Invalid status: got '%s' should be '%s'", test.status, test.valid)
+		}
+	}
+
+	if !(StatusFingerOut | StatusSearching).Has(StatusSearching) || StatusFingerOut.Has(StatusSearching) {
+		t.Errorf("Invalid status flags")
+	}
+}
+
+func TestRecordZones(t *testing.T) {
+	db, err := newTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	newYork := time.FixedZone("EST", -5*60*60)
+	start := time.Date(2018, time.November, 24, 14, 0, 0, 0, time.UTC)
+
+	data := []*OxiRecord{
+		&OxiRecord{DateTime: start.Add(time.Hour).In(tokyo), Pulse: 61, Spo2: 96, SessionID: 1},
+		&OxiRecord{DateTime: start.In(newYork), Pulse: 60, Spo2: 97, SessionID: 1},
+		&OxiRecord{DateTime: start.In(tokyo), Pulse: 60, Spo2: 97, SessionID: 1},
+	}
+
+	err = db.SaveRecords(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same instant in two zones is one record
+	records, err := db.FetchRecords(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || !records[0].DateTime.Equal(start) || !records[1].DateTime.Equal(start.Add(time.Hour)) {
+		t.Errorf("Invalid records in time order: %v", records)
+	}
+
+	records, err = db.FetchRecords(start.Add(30*time.Minute).In(newYork), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 1 || !records[0].DateTime.Equal(start.Add(time.Hour)) {
+		t.Errorf("Invalid records after %s: %v", start.Add(30*time.Minute), records)
+	}
+
+	count := 0
+	err = db.StreamRecords(&RecordQuery{To: start.Add(30 * time.Minute).In(tokyo)}, func(rec *OxiRecord) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("Invalid number of records before %s. Got %d wanted %d", start.Add(30*time.Minute), count, 1)
+	}
 }
@@ -22,29 +22,107 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	sessionColumns = `
+            id,
+            start_time,
+            model,
+            duration_seconds,
+            vendor,
+            device_id,
+            device_info,
+            sample_interval,
+            time_zone,
+            clock_drift,
+            drift_correction,
+            source`
+)
+
 type Session struct {
-	ID        int64     `db:"id" json:"id"`
-	StartTime time.Time `db:"start_time" json:"start_time"`
-	Model     string    `db:"model" json:"model"`
-	Seconds   int       `db:"duration_seconds" json:"duration_seconds"`
+	ID         int64     `db:"id" json:"id"`
+	StartTime  time.Time `db:"start_time" json:"start_time"`
+	Model      string    `db:"model" json:"model"`
+	Seconds    int       `db:"duration_seconds" json:"duration_seconds"`
+	Vendor     string    `db:"vendor" json:"vendor"`
+	DeviceID   string    `db:"device_id" json:"device_id"`
+	DeviceInfo string    `db:"device_info" json:"device_info"`
+
+	// Seconds between samples
+	SampleInterval int `db:"sample_interval" json:"sample_interval"`
+
+	// IANA time zone name or UTC offset the session was recorded in
+	TimeZone string `db:"time_zone" json:"time_zone"`
+
+	// Seconds the device clock was ahead of the host clock at import and
+	// seconds the session start time was moved back to correct for it
+	ClockDrift      int `db:"clock_drift" json:"clock_drift"`
+	DriftCorrection int `db:"drift_correction" json:"drift_correction"`
+
+	// File format the session was imported from. Empty for sessions
+	// downloaded from a device
+	Source string `db:"source" json:"source"`
+}
+
+// Location returns the time zone the session was recorded in. Sessions with
+// an unknown time zone use the host time zone.
+func (s *Session) Location() *time.Location {
+	loc, err := LoadLocation(s.TimeZone)
+	if err != nil {
+		log.Warnf("Session %d: %s. Using local time", s.ID, err)
+		return time.Local
+	}
+
+	return loc
+}
+
+// Interval returns the time between samples. Sessions without a sample
+// interval have one sample per second.
+func (s *Session) Interval() time.Duration {
+	if s.SampleInterval <= 0 {
+		return time.Second
+	}
+
+	return time.Duration(s.SampleInterval) * time.Second
 }
 
 func (s *Session) String() string {
 	return fmt.Sprintf(
-		"ID=%d StartTime=%s Model=%s Duration=%s",
+		"ID=%d StartTime=%s Model=%s DeviceID=%s Duration=%s",
 		s.ID,
-		s.StartTime.Format("2006-01-02 15:04:05"),
+		s.StartTime.In(s.Location()).Format("2006-01-02 15:04:05 MST"),
 		s.Model,
+		s.DeviceID,
 		time.Duration(time.Second*time.Duration(s.Seconds)))
 }
 
 func (db *DB) SaveSession(session *Session) error {
-	res, err := db.NamedExec(`
-        insert into session (start_time, model, duration_seconds) 
-        values (:start_time, :model, :duration_seconds)`, session)
+	return saveSession(db, session)
+}
+
+func (db *DB) UpdateSession(session *Session) error {
+	return updateSession(db, session)
+}
+
+func saveSession(ext sqlx.Ext, session *Session) error {
+	if session.SampleInterval <= 0 {
+		session.SampleInterval = 1
+	}
+	if len(session.TimeZone) == 0 {
+		session.TimeZone = LocalZoneName()
+	}
+
+	row := *session
+	row.StartTime = row.StartTime.UTC()
+
+	res, err := sqlx.NamedExec(ext, `
+        insert into session (start_time, model, duration_seconds, vendor, device_id, device_info, sample_interval, time_zone,
+            clock_drift, drift_correction, source) 
+        values (:start_time, :model, :duration_seconds, :vendor, :device_id, :device_info, :sample_interval, :time_zone,
+            :clock_drift, :drift_correction, :source)`, &row)
 	if err != nil {
 		return err
 	}
@@ -57,13 +135,46 @@ func (db *DB) SaveSession(session *Session) error {
 	return nil
 }
 
+func updateSession(ext sqlx.Ext, session *Session) error {
+	row := *session
+	row.StartTime = row.StartTime.UTC()
+
+	_, err := sqlx.NamedExec(ext, `
+        update session set start_time = :start_time, model = :model, duration_seconds = :duration_seconds,
+            vendor = :vendor, device_id = :device_id, device_info = :device_info, sample_interval = :sample_interval,
+            time_zone = :time_zone, clock_drift = :clock_drift, drift_correction = :drift_correction,
+            source = :source
+        where id = :id`, &row)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) FetchSession(id int64) (*Session, error) {
+	query := `
+        select ` + sessionColumns + `
+        from session
+        where id = ?
+	`
+
+	log.Debugf("Fetch Session by id query: %s", query)
+
+	session := &Session{}
+	err := db.Get(session, query, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
 func (db *DB) FetchSessionByStartTime(start time.Time) (*Session, error) {
 	query := `
-        select
-			id,
-			start_time,
-			model,
-            duration_seconds
+        select ` + sessionColumns + `
         from session
         where start_time = ?
 	`
@@ -71,7 +182,7 @@ func (db *DB) FetchSessionByStartTime(start time.Time) (*Session, error) {
 	log.Debugf("Fetch Last Session by start time query: %s", query)
 
 	session := &Session{}
-	err := db.Get(session, query, start)
+	err := db.Get(session, query, start.UTC())
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	} else if err != nil {
@@ -83,11 +194,7 @@ func (db *DB) FetchSessionByStartTime(start time.Time) (*Session, error) {
 
 func (db *DB) FetchLatestSession() (*Session, error) {
 	query := `
-        select
-			id,
-			start_time,
-			model,
-            duration_seconds
+        select ` + sessionColumns + `
         from session
         order by start_time desc
         limit 1
@@ -108,11 +215,7 @@ func (db *DB) FetchLatestSession() (*Session, error) {
 
 func (db *DB) FetchPreviousSession() (*Session, error) {
 	query := `
-        select
-			id,
-			start_time,
-			model,
-            duration_seconds
+        select ` + sessionColumns + `
         from session
         order by start_time desc
         limit 1 offset 1
@@ -133,11 +236,7 @@ func (db *DB) FetchPreviousSession() (*Session, error) {
 
 func (db *DB) FetchAllSessions() ([]*Session, error) {
 	query := `
-        select
-			id,
-			start_time,
-			model,
-            duration_seconds
+        select ` + sessionColumns + `
         from session
 	`
 	log.Debugf("Fetch All Sessions query: %s", query)
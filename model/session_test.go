@@ -31,8 +31,8 @@ func TestSession(t *testing.T) {
 	start := time.Now()
 
 	data := []*Session{
-		&Session{StartTime: start, Model: "50F", Seconds: 3600},
-		&Session{StartTime: start.Add(-time.Second * 86400), Model: "50F", Seconds: 28800},
+		&Session{StartTime: start, Model: "50F", Seconds: 3600, Vendor: "CONTEC", DeviceID: "0154321", DeviceInfo: "V1.2"},
+		&Session{StartTime: start.Add(-time.Second * 86400), Model: "50F", Seconds: 28800, SampleInterval: 4, TimeZone: "+05:30"},
 	}
 
 	for _, s := range data {
@@ -78,6 +78,14 @@ func TestSession(t *testing.T) {
 		t.Errorf("Invalid session ID for session returned. Got %d wanted %d", session.ID, 1)
 	}
 
+	if session.Vendor != data[0].Vendor || session.DeviceID != data[0].DeviceID || session.DeviceInfo != data[0].DeviceInfo {
+		t.Errorf("Invalid device identity for session returned. Got %s wanted %s", session, data[0])
+	}
+
+	if session.SampleInterval != 1 || session.Interval() != time.Second {
+		t.Errorf("Invalid default sample interval for session returned. Got %d wanted %d", session.SampleInterval, 1)
+	}
+
 	session, err = db.FetchPreviousSession()
 	if err != nil {
 		t.Error(err)
@@ -86,4 +94,27 @@ func TestSession(t *testing.T) {
 	if session.ID != 2 {
 		t.Errorf("Invalid session ID for previous session returned. Got %d wanted %d", session.ID, 1)
 	}
+
+	session.Seconds = 7200
+	err = db.UpdateSession(session)
+	if err != nil {
+		t.Error(err)
+	}
+
+	session, err = db.FetchSessionByStartTime(data[1].StartTime)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if session.Seconds != 7200 {
+		t.Errorf("Invalid duration for updated session returned. Got %d wanted %d", session.Seconds, 7200)
+	}
+
+	if session.Interval() != 4*time.Second {
+		t.Errorf("Invalid sample interval for session returned. Got %s wanted %s", session.Interval(), 4*time.Second)
+	}
+
+	if _, offset := session.StartTime.In(session.Location()).Zone(); session.TimeZone != "+05:30" || offset != 5*3600+1800 {
+		t.Errorf("Invalid time zone for session returned. Got %s wanted %s", session.TimeZone, "+05:30")
+	}
 }
@@ -0,0 +1,86 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// LocalZoneName returns the IANA name of the host time zone, for example
+// America/New_York. If the name can't be found the current UTC offset is
+// returned instead, for example -05:00.
+func LocalZoneName() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); len(tz) > 0 {
+		if _, err := time.LoadLocation(tz); err == nil {
+			return tz
+		}
+	}
+
+	if name := time.Local.String(); name != "Local" {
+		return name
+	}
+
+	if link, err := os.Readlink("/etc/localtime"); err == nil {
+		if i := strings.Index(link, "zoneinfo/"); i >= 0 {
+			name := link[i+len("zoneinfo/"):]
+			if _, err := time.LoadLocation(name); err == nil {
+				return name
+			}
+		}
+	}
+
+	_, offset := time.Now().Zone()
+	return time.Unix(0, 0).In(time.FixedZone("", offset)).Format("-07:00")
+}
+
+// LoadLocation returns the location for an IANA time zone name such as
+// Europe/Paris or a fixed UTC offset such as +05:30. An empty name is the
+// host time zone.
+func LoadLocation(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		return time.Local, nil
+	}
+
+	if name[0] == '+' || name[0] == '-' {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			t, err := time.Parse(layout, name)
+			if err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(name, offset), nil
+			}
+		}
+
+		return nil, fmt.Errorf("Invalid UTC offset %q", name)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown time zone %q: %s", name, err)
+	}
+
+	return loc, nil
+}
+
+// InZone returns the wall clock time of t, ignoring its location, in loc. This
+// converts a time read from a device clock set to another time zone.
+func InZone(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadLocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+	}{
+		{"UTC", 0},
+		{"+05:30", 5*3600 + 1800},
+		{"-0700", -7 * 3600},
+		{"-03", -3 * 3600},
+	}
+
+	for _, test := range tests {
+		loc, err := LoadLocation(test.name)
+		if err != nil {
+			t.Errorf("Failed to load time zone %s: %s", test.name, err)
+			continue
+		}
+
+		_, offset := time.Date(2018, time.November, 24, 23, 0, 0, 0, loc).Zone()
+		if offset != test.offset {
+			t.Errorf("Invalid offset for %s: got '%d' should be '%d'", test.name, offset, test.offset)
+		}
+	}
+
+	for _, name := range []string{"Mars/Olympus", "+5:3x"} {
+		_, err := LoadLocation(name)
+		if err == nil {
+			t.Errorf("Expected error loading time zone %s", name)
+		}
+	}
+
+	loc, err := LoadLocation("")
+	if err != nil || loc != time.Local {
+		t.Errorf("Empty time zone should be local time")
+	}
+
+	_, err = LoadLocation(LocalZoneName())
+	if err != nil {
+		t.Errorf("Failed to load local time zone %s: %s", LocalZoneName(), err)
+	}
+}
+
+func TestInZone(t *testing.T) {
+	loc, err := LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wall := time.Date(2018, time.November, 24, 23, 0, 0, 0, time.UTC)
+	in := InZone(wall, loc)
+
+	if !in.Equal(time.Date(2018, time.November, 25, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("Invalid time in zone: got '%s' should be '%s'", in.UTC(), "2018-11-25 04:00:00 UTC")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// ImportTx saves a session and its records in a single transaction so an
+// interrupted import leaves nothing behind
+type ImportTx interface {
+	SaveSession(session *Session) error
+	UpdateSession(session *Session) error
+	SaveRecords(records []*OxiRecord) error
+	DeleteRecordsBySessionID(id int64) error
+	Commit() error
+	Rollback() error
+}
+
+type importTx struct {
+	*sqlx.Tx
+}
+
+func (db *DB) BeginImport() (ImportTx, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	return &importTx{tx}, nil
+}
+
+func (tx *importTx) SaveSession(session *Session) error {
+	return saveSession(tx, session)
+}
+
+func (tx *importTx) UpdateSession(session *Session) error {
+	return updateSession(tx, session)
+}
+
+func (tx *importTx) SaveRecords(records []*OxiRecord) error {
+	return saveRecords(tx, records)
+}
+
+func (tx *importTx) DeleteRecordsBySessionID(id int64) error {
+	_, err := tx.Exec(`delete from oxi_record where session_id = ?`, id)
+	return err
+}
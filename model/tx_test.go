@@ -0,0 +1,165 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportTx(t *testing.T) {
+	db, err := newTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	records := []*OxiRecord{
+		&OxiRecord{DateTime: start, Pulse: 60, Spo2: 97},
+		&OxiRecord{DateTime: start.Add(time.Second), Pulse: 61, Spo2: 96},
+	}
+
+	// Rolled back imports leave nothing behind
+	tx, err := db.BeginImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := &Session{StartTime: start, Model: "50F", Seconds: 2}
+	err = tx.SaveSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range records {
+		r.SessionID = session.ID
+	}
+
+	err = tx.SaveRecords(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.FetchSessionByStartTime(start)
+	if err != ErrNotFound {
+		t.Errorf("Session should not exist after rollback: %v", err)
+	}
+
+	count, err := db.CountRecordsBySessionID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Invalid number of records after rollback: got '%d' should be '%d'", count, 0)
+	}
+
+	// Committed imports save the session and records
+	tx, err = db.BeginImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.SaveSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range records {
+		r.SessionID = session.ID
+	}
+
+	err = tx.SaveRecords(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.Seconds = 3
+	err = tx.UpdateSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := db.FetchSessionByStartTime(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved.Seconds != 3 {
+		t.Errorf("Invalid session duration: got '%d' should be '%d'", saved.Seconds, 3)
+	}
+
+	count, err = db.CountRecordsBySessionID(saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != len(records) {
+		t.Errorf("Invalid number of records: got '%d' should be '%d'", count, len(records))
+	}
+
+	// Records of other sessions are kept when deleting a session's records
+	other := &OxiRecord{DateTime: start.Add(time.Hour), SessionID: saved.ID + 1, Pulse: 62, Spo2: 95}
+	err = db.SaveRecords([]*OxiRecord{other})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.BeginImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.DeleteRecordsBySessionID(saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err = db.CountRecordsBySessionID(saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Invalid number of records after delete: got '%d' should be '%d'", count, 0)
+	}
+
+	count, err = db.CountRecordsBySessionID(other.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("Invalid number of records for other session: got '%d' should be '%d'", count, 1)
+	}
+}
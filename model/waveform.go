@@ -0,0 +1,79 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type WaveformSample struct {
+	DateTime  time.Time `db:"date_time" json:"date_time"`
+	SessionID int64     `db:"session_id" json:"session_id"`
+	Pleth     uint8     `db:"pleth" json:"pleth"`
+}
+
+func (w *WaveformSample) String() string {
+	return fmt.Sprintf("DateTime=%s Pleth=%d", w.DateTime.Format("2006-01-02 15:04:05.000"), w.Pleth)
+}
+
+func (db *DB) SaveWaveform(samples []*WaveformSample) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, sample := range samples {
+		row := *sample
+		row.DateTime = row.DateTime.UTC()
+
+		_, err := tx.NamedExec(`
+            replace into waveform (date_time, session_id, pleth) 
+            values (:date_time, :session_id, :pleth)`, &row)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) FetchWaveformBySessionID(sessionID int64) ([]*WaveformSample, error) {
+	query := `
+        select
+			date_time,
+            session_id,
+			pleth
+        from waveform
+        where session_id = ?
+        order by date_time asc
+	`
+
+	log.Debugf("Fetch Waveform by session id query: %s", query)
+
+	data := []*WaveformSample{}
+	err := db.Select(&data, query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaveform(t *testing.T) {
+	db, err := newTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	step := time.Second / 60
+
+	data := []*WaveformSample{
+		&WaveformSample{DateTime: start.Add(step * 1), Pleth: 12, SessionID: 1},
+		&WaveformSample{DateTime: start.Add(step * 2), Pleth: 25, SessionID: 1},
+		&WaveformSample{DateTime: start.Add(step * 3), Pleth: 41, SessionID: 1},
+		&WaveformSample{DateTime: start.Add(step * 4), Pleth: 38, SessionID: 1},
+		&WaveformSample{DateTime: start.Add(step * 5), Pleth: 20, SessionID: 1},
+		&WaveformSample{DateTime: start.Add(step * 1), Pleth: 33, SessionID: 2},
+	}
+
+	err = db.SaveWaveform(data)
+	if err != nil {
+		t.Error(err)
+	}
+
+	samples, err := db.FetchWaveformBySessionID(1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(samples) != 5 {
+		t.Fatalf("Invalid number of waveform samples returned. Got %d wanted %d", len(samples), 5)
+	}
+
+	for i := range samples {
+		if samples[i].DateTime.UTC() != data[i].DateTime.UTC() {
+			t.Errorf("Invalid datetime for sample %d. Got %s wanted %s", i, samples[i].DateTime.UTC(), data[i].DateTime.UTC())
+		}
+		if samples[i].Pleth != data[i].Pleth {
+			t.Errorf("Invalid pleth for sample %d. Got %d wanted %d", i, samples[i].Pleth, data[i].Pleth)
+		}
+	}
+
+	// A failed insert saves none of the batch
+	_, err = db.(*DB).Exec(`
+		create trigger waveform_reject before insert on waveform when new.pleth = 255
+		begin select raise(abort, 'rejected'); end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SaveWaveform([]*WaveformSample{
+		&WaveformSample{DateTime: start, Pleth: 10, SessionID: 3},
+		&WaveformSample{DateTime: start.Add(step), Pleth: 255, SessionID: 3},
+	})
+	if err == nil {
+		t.Errorf("Expected error saving rejected waveform sample")
+	}
+
+	samples, err = db.FetchWaveformBySessionID(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(samples) != 0 {
+		t.Errorf("Invalid number of waveform samples after failed save. Got %d wanted %d", len(samples), 0)
+	}
+}
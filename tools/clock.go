@@ -0,0 +1,208 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aebruno/myoxi/device"
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// clockSkew returns how far the device clock is ahead of the host clock. If loc
+// is set the device clock is read as wall clock time in loc.
+func clockSkew(ctx context.Context, device device.Device, loc *time.Location) (time.Duration, error) {
+	deviceTime, err := device.GetTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if loc != nil {
+		deviceTime = model.InZone(deviceTime, loc)
+	}
+
+	return deviceTime.Sub(time.Now()).Round(time.Second), nil
+}
+
+// CheckClock warns if the device clock differs from the host clock by more
+// than threshold. A threshold of 0 disables the check. If loc is set the device
+// clock is expected to be set to the time in loc instead of local time. The
+// check is advisory so failing to read the clock is only a warning.
+func CheckClock(ctx context.Context, dev device.Device, threshold time.Duration, loc *time.Location) {
+	if threshold == 0 {
+		return
+	}
+
+	skew, err := clockSkew(ctx, dev, loc)
+	if err == device.ErrNotSupported {
+		log.Debug("Device does not have a clock. Skipping clock check")
+		return
+	} else if err != nil {
+		warnClockError(err)
+		return
+	}
+
+	warnSkew(skew, threshold)
+}
+
+// warnClockError warns that the device clock could not be read for the clock
+// check
+func warnClockError(err error) {
+	log.WithFields(log.Fields{
+		"error": err,
+	}).Warn("Failed to read device clock. Skipping clock check. Run 'myoxi device set-time' if the clock is off")
+}
+
+// measureDrift returns how far the device clock is ahead of the host clock and
+// the host time it was measured. ok is false if the device has no clock.
+func measureDrift(ctx context.Context, dev device.Device, loc *time.Location) (drift time.Duration, now time.Time, ok bool, err error) {
+	now = time.Now()
+	drift, err = clockSkew(ctx, dev, loc)
+	if err == device.ErrNotSupported {
+		log.Debug("Device does not have a clock. Skipping clock drift measurement")
+		return 0, now, false, nil
+	} else if err != nil {
+		return 0, now, false, fmt.Errorf("Failed to get device time: %s", err)
+	}
+
+	return drift, now, true, nil
+}
+
+// loadDriftCorrection returns the drift correction for a device from the last
+// time its clock was set. Nil is returned if the clock has never been set with
+// myoxi.
+func loadDriftCorrection(db model.Datastore, deviceID string, now time.Time, drift time.Duration) (*driftCorrection, error) {
+	set, err := db.FetchLastClockSet(deviceID)
+	if err == model.ErrNotFound {
+		log.Warn("Device clock has not been set with 'myoxi device set-time'. Not correcting clock drift")
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to fetch clock set time from database: %s", err)
+	}
+
+	log.Infof("Correcting clock drift of %s since the device clock was set at %s", drift, set.SetTime.Local().Format("2006-01-02 15:04:05"))
+
+	return newDriftCorrection(set.SetTime, now, drift), nil
+}
+
+// warnSkew warns if skew is larger than threshold. A threshold of 0 disables
+// the warning.
+func warnSkew(skew, threshold time.Duration) {
+	log.Debugf("Device clock skew: %s", skew)
+
+	if threshold == 0 {
+		return
+	}
+
+	if skew > threshold || skew < -threshold {
+		log.WithFields(log.Fields{
+			"skew":      skew,
+			"threshold": threshold,
+		}).Warn("Device clock differs from host clock. Run 'myoxi device set-time' to fix")
+	}
+}
+
+// SetClock sets the device clock to the host local time and saves the time it
+// was set to the database for drift correction
+func SetClock(ctx context.Context, db model.Datastore, device device.Device) error {
+	err := device.ResetDevice(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to reset device: %s", err)
+	}
+
+	identity, err := device.GetIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get device identity: %s", err)
+	}
+
+	skew, err := clockSkew(ctx, device, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to get device time: %s", err)
+	}
+
+	log.Infof("Device clock skew before setting time: %s", skew)
+
+	setTime := time.Now()
+	err = device.SetTime(ctx, setTime)
+	if err != nil {
+		return fmt.Errorf("Failed to set device time: %s", err)
+	}
+
+	err = db.SaveClockSet(&model.ClockSet{DeviceID: identity.DeviceID, SetTime: setTime, Skew: int(skew.Seconds())})
+	if err != nil {
+		return fmt.Errorf("Failed to save clock set time to database: %s", err)
+	}
+
+	skew, err = clockSkew(ctx, device, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to get device time: %s", err)
+	}
+
+	log.Infof("Device clock skew after setting time: %s", skew)
+
+	return nil
+}
+
+// driftCorrection rescales device times linearly between the last time the
+// device clock was set, when it had no drift, and when the drift was measured
+type driftCorrection struct {
+	// Host time the device clock was last set
+	set time.Time
+
+	// Device time when the drift was measured
+	measured time.Time
+
+	// How far the device clock was ahead of the host clock
+	drift time.Duration
+}
+
+// newDriftCorrection returns the correction for a device whose clock was set
+// at set and is drift ahead of the host clock at now
+func newDriftCorrection(set, now time.Time, drift time.Duration) *driftCorrection {
+	return &driftCorrection{set: set, measured: now.Add(drift), drift: drift}
+}
+
+// offset returns how far the device clock was ahead of the host clock at
+// device time t
+func (d *driftCorrection) offset(t time.Time) time.Duration {
+	if d == nil {
+		return 0
+	}
+
+	span := d.measured.Sub(d.set)
+	if span <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(d.drift) * float64(t.Sub(d.set)) / float64(span))
+}
+
+// correct converts device time t to host time. A nil correction leaves t
+// unchanged.
+func (d *driftCorrection) correct(t time.Time) time.Time {
+	return t.Add(-d.offset(t))
+}
+
+// correctStart converts a session start time to host time rounded to the
+// second so the start time is stable between imports
+func (d *driftCorrection) correctStart(t time.Time) time.Time {
+	return t.Add(-d.offset(t).Round(time.Second))
+}
@@ -18,49 +18,51 @@
 package tools
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/aebruno/myoxi/device"
 )
 
-func DeviceInfo(device device.Device) error {
-	err := device.ResetDevice()
+func DeviceInfo(ctx context.Context, device device.Device, maxClockSkew time.Duration) error {
+	err := device.ResetDevice(ctx)
 	if err != nil {
 		return fmt.Errorf("Failed to reset device: %s", err)
 	}
 
-	user, err := device.GetUser()
-	if err != nil {
-		return fmt.Errorf("Failed to get user: %s", err)
-	}
+	CheckClock(ctx, device, maxClockSkew, nil)
 
-	model, err := device.GetModel()
+	identity, err := device.GetIdentity(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed to get device model: %s", err)
+		return fmt.Errorf("Failed to get device identity: %s", err)
 	}
 
-	count, err := device.GetSessionCount()
+	count, err := device.GetSessionCount(ctx)
 	if err != nil {
 		return fmt.Errorf("Failed to get session count: %s", err)
 	}
 
-	fmt.Printf("Device model: %s\n", model)
-	fmt.Printf("Userinfo: %s\n", user)
+	fmt.Printf("Device vendor: %s\n", identity.Vendor)
+	fmt.Printf("Device model: %s\n", identity.Model)
+	fmt.Printf("Device ID: %s\n", identity.DeviceID)
+	fmt.Printf("Device info: %s\n", identity.Info)
+	fmt.Printf("Userinfo: %s\n", identity.User)
 	fmt.Printf("Session count: %d\n", count)
 	fmt.Printf("------------------------------\n")
 
 	for i := uint8(0); i < count; i++ {
-		duration, err := device.GetSessionDuration(i)
+		duration, err := device.GetSessionDuration(ctx, i)
 		if err != nil {
 			return fmt.Errorf("Failed to fetch session duration: %s", err)
 		}
 
-		startTime, err := device.GetSessionTime(i)
+		startTime, err := device.GetSessionTime(ctx, i)
 		if err != nil {
 			return fmt.Errorf("Failed to fetch session time: %s", err)
 		}
 
-		fmt.Printf("- %s (%s)\n", startTime, duration)
+		fmt.Printf("- %d: %s (%s)\n", i+1, startTime, duration)
 	}
 
 	return nil
@@ -0,0 +1,131 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aebruno/myoxi/device"
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// verifySession checks the device session has been saved to the database with
+// a matching record count
+func verifySession(ctx context.Context, db model.Datastore, device device.Device, ds *deviceSession) error {
+	session := ds.existing
+	if session == nil {
+		return fmt.Errorf("Session %s (%s) has not been imported", ds.start, ds.duration)
+	}
+
+	count, err := db.CountRecordsBySessionID(session.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to count records in database: %s", err)
+	}
+
+	log.Infof("Verifying session %d - %s (%s)", session.ID, ds.start, ds.duration)
+	data, err := device.GetSessionData(ctx, ds.index)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch session data: %s", err)
+	}
+
+	// Import saves at most one record per sample interval of the session
+	// duration
+	total := int(ds.duration / session.Interval())
+	if total > len(data) {
+		total = len(data)
+	}
+
+	if count != total {
+		return fmt.Errorf("Session %d has %d records in the database but %d on the device", session.ID, count, total)
+	}
+
+	return nil
+}
+
+// Erase clears all sessions from the device memory. Unless force is true each
+// session is first verified to be in the database. If timeZone is set the
+// device clock is read as wall clock time in that zone, as with import --tz.
+func Erase(ctx context.Context, db model.Datastore, device device.Device, force bool, timeZone string) error {
+	var loc *time.Location
+	if len(timeZone) > 0 {
+		var err error
+		loc, err = model.LoadLocation(timeZone)
+		if err != nil {
+			return err
+		}
+	}
+
+	return erase(ctx, db, device, force, loc)
+}
+
+func erase(ctx context.Context, db model.Datastore, device device.Device, force bool, loc *time.Location) error {
+	err := device.ResetDevice(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to reset device: %s", err)
+	}
+
+	count, err := device.GetSessionCount(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get session count: %s", err)
+	}
+
+	if count == 0 {
+		log.Warn("No sessions found. Nothing to erase")
+		return nil
+	}
+
+	if force {
+		log.Warnf("Erasing %d sessions without verifying they have been imported", count)
+		return eraseDevice(ctx, device, int(count))
+	}
+
+	// Sessions are found the same way import finds them
+	sessions, err := listSessions(ctx, db, device, count, loc, nil, true)
+	if err != nil {
+		return err
+	}
+
+	return eraseSessions(ctx, db, device, sessions)
+}
+
+// eraseSessions erases the device after verifying each of its sessions has
+// been saved to the database
+func eraseSessions(ctx context.Context, db model.Datastore, device device.Device, sessions []*deviceSession) error {
+	for _, ds := range sessions {
+		err := verifySession(ctx, db, device, ds)
+		if err != nil {
+			return fmt.Errorf("Refusing to erase device. Use --force to erase anyway: %s", err)
+		}
+	}
+
+	return eraseDevice(ctx, device, len(sessions))
+}
+
+func eraseDevice(ctx context.Context, device device.Device, count int) error {
+	err := device.EraseSessions(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to erase device: %s", err)
+	}
+
+	log.Infof("Erased %d sessions from device", count)
+
+	return nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ExportCSV    = "csv"
+	ExportTSV    = "tsv"
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+// ExportFormats are the formats supported by Export
+var ExportFormats = []string{ExportCSV, ExportTSV, ExportJSON, ExportNDJSON}
+
+// CheckExportFormat returns an error if format is not one of ExportFormats
+func CheckExportFormat(format string) error {
+	for _, f := range ExportFormats {
+		if f == format {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Unknown export format %q. Use one of %v", format, ExportFormats)
+}
+
+// recordWriter writes records to an export file one at a time with the
+// session they belong to
+type recordWriter interface {
+	Write(rec *model.OxiRecord, session *model.Session) error
+	Close() error
+}
+
+func newRecordWriter(format string, out io.Writer) (recordWriter, error) {
+	switch format {
+	case ExportCSV:
+		return newDelimitedWriter(out, ',')
+	case ExportTSV:
+		return newDelimitedWriter(out, '\t')
+	case ExportJSON:
+		return &jsonWriter{out: out, array: true}, nil
+	case ExportNDJSON:
+		return &jsonWriter{out: out}, nil
+	}
+
+	return nil, CheckExportFormat(format)
+}
+
+// delimitedWriter writes records as CSV or TSV with a header row. Each row
+// repeats the details of the session of the record.
+type delimitedWriter struct {
+	w *csv.Writer
+}
+
+func newDelimitedWriter(out io.Writer, comma rune) (*delimitedWriter, error) {
+	w := csv.NewWriter(out)
+	w.Comma = comma
+
+	err := w.Write([]string{
+		"date_time", "session_id", "pulse", "spo2", "status", "motion",
+		"model", "vendor", "device_id", "device_info", "sample_interval",
+		"time_zone", "clock_drift", "drift_correction", "source",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &delimitedWriter{w: w}, nil
+}
+
+func (d *delimitedWriter) Write(rec *model.OxiRecord, session *model.Session) error {
+	if session == nil {
+		session = &model.Session{}
+	}
+
+	return d.w.Write([]string{
+		rec.DateTime.Format(time.RFC3339),
+		strconv.FormatInt(rec.SessionID, 10),
+		strconv.Itoa(int(rec.Pulse)),
+		strconv.Itoa(int(rec.Spo2)),
+		strconv.Itoa(int(rec.Status)),
+		strconv.Itoa(int(rec.Motion)),
+		session.Model,
+		session.Vendor,
+		session.DeviceID,
+		session.DeviceInfo,
+		strconv.Itoa(session.SampleInterval),
+		session.TimeZone,
+		strconv.Itoa(session.ClockDrift),
+		strconv.Itoa(session.DriftCorrection),
+		session.Source,
+	})
+}
+
+func (d *delimitedWriter) Close() error {
+	d.w.Flush()
+	return d.w.Error()
+}
+
+// jsonRecord is a record in a JSON export. The first record of each session
+// holds the session.
+type jsonRecord struct {
+	*model.OxiRecord
+	Session *model.Session `json:"session,omitempty"`
+}
+
+// jsonWriter writes records as a JSON array or, if array is false, as one JSON
+// object per line
+type jsonWriter struct {
+	out   io.Writer
+	array bool
+	count int
+	seen  map[int64]bool
+}
+
+func (j *jsonWriter) Write(rec *model.OxiRecord, session *model.Session) error {
+	if j.seen == nil {
+		j.seen = make(map[int64]bool)
+	}
+
+	row := &jsonRecord{OxiRecord: rec}
+	if !j.seen[rec.SessionID] {
+		row.Session = session
+		j.seen[rec.SessionID] = true
+	}
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	if j.array {
+		prefix := ",\n"
+		if j.count == 0 {
+			prefix = "[\n"
+		}
+		data = append([]byte(prefix), data...)
+	} else {
+		data = append(data, '\n')
+	}
+
+	j.count++
+	_, err = j.out.Write(data)
+
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.array {
+		return nil
+	}
+
+	if j.count == 0 {
+		_, err := io.WriteString(j.out, "[]\n")
+		return err
+	}
+
+	_, err := io.WriteString(j.out, "\n]\n")
+	return err
+}
+
+// Export writes the records selected by query to out in format (csv, tsv, json
+// or ndjson). Records are streamed from the database and times are written in
+// the time zone of their session. The device, time zone, clock drift and
+// source of the session are written on each CSV and TSV row and on the first
+// JSON record of each session.
+func Export(db model.Datastore, query *model.RecordQuery, format string, out io.Writer) error {
+	buf := bufio.NewWriter(out)
+	w, err := newRecordWriter(format, buf)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		return fmt.Errorf("Failed to fetch sessions from database: %s", err)
+	}
+	zones := sessionZones(sessions)
+	byID := make(map[int64]*model.Session, len(sessions))
+	for _, session := range sessions {
+		session.StartTime = session.StartTime.In(zones[session.ID])
+		byID[session.ID] = session
+	}
+
+	count := 0
+	err = db.StreamRecords(query, func(rec *model.OxiRecord) error {
+		if loc, ok := zones[rec.SessionID]; ok {
+			rec.DateTime = rec.DateTime.In(loc)
+		}
+
+		count++
+		return w.Write(rec, byID[rec.SessionID])
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to export records: %s", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	err = buf.Flush()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		log.Warn("No records found to export")
+	} else {
+		log.Infof("Exported %d records", count)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+func TestExport(t *testing.T) {
+	db := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?sessions=2&duration=10m")
+
+	err := Import(context.Background(), db, sim, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = Export(db, &model.RecordQuery{}, ExportCSV, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := "date_time,session_id,pulse,spo2,status,motion,model,vendor,device_id,device_info,sample_interval,time_zone,clock_drift,drift_correction,source"
+	if len(rows) != 1201 || strings.Join(rows[0], ",") != header {
+		t.Errorf("Invalid CSV export. Got %d rows with header %v wanted %d", len(rows), rows[0], 1201)
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{sessions[0].Model, sessions[0].Vendor, sessions[0].DeviceID, sessions[0].DeviceInfo, "1", model.LocalZoneName(), "0", "0", ""}
+	if len(rows[1]) != 15 || strings.Join(rows[1][6:], ",") != strings.Join(want, ",") || len(want[0]) == 0 {
+		t.Errorf("Invalid CSV session columns. Got %v wanted %v", rows[1][6:], want)
+	}
+
+	out.Reset()
+	err = Export(db, &model.RecordQuery{}, ExportTSV, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(out.String(), "date_time\tsession_id\t") {
+		t.Errorf("Invalid TSV export header: %q", strings.SplitN(out.String(), "\n", 2)[0])
+	}
+
+	out.Reset()
+	err = Export(db, &model.RecordQuery{SessionID: 2}, ExportJSON, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := make([]*jsonRecord, 0)
+	err = json.Unmarshal(out.Bytes(), &records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 600 || records[0].SessionID != 2 {
+		t.Errorf("Invalid JSON export. Got %d records wanted %d from session %d", len(records), 600, 2)
+	}
+
+	if records[0].Session == nil || records[0].Session.ID != 2 || records[0].Session.DeviceID != sessions[1].DeviceID {
+		t.Errorf("Invalid JSON export. First record has session %v wanted %v", records[0].Session, sessions[1])
+	}
+
+	if records[1].Session != nil {
+		t.Errorf("Invalid JSON export. Got session %v on second record wanted none", records[1].Session)
+	}
+
+	out.Reset()
+	err = Export(db, &model.RecordQuery{}, ExportNDJSON, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	headers := 0
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		rec := &jsonRecord{}
+		err := json.Unmarshal(scanner.Bytes(), rec)
+		if err != nil {
+			t.Fatalf("Invalid NDJSON line %d: %s", lines, err)
+		}
+		if rec.Session != nil {
+			headers++
+		}
+		lines++
+	}
+
+	if lines != 1200 || headers != 2 {
+		t.Errorf("Invalid NDJSON export. Got %d lines with %d sessions wanted %d with %d", lines, headers, 1200, 2)
+	}
+
+	out.Reset()
+	err = Export(db, &model.RecordQuery{SessionID: 3}, ExportJSON, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "[]\n" {
+		t.Errorf("Invalid empty JSON export: %q", out.String())
+	}
+
+	err = Export(db, &model.RecordQuery{}, "xml", &out)
+	if err == nil {
+		t.Errorf("Expected error for unknown export format")
+	}
+}
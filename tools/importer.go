@@ -18,7 +18,9 @@
 package tools
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/aebruno/myoxi/device"
@@ -26,98 +28,467 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func Import(db model.Datastore, device device.Device, noop, forceOverwrite bool) error {
-	err := device.ResetDevice()
-	if err != nil {
-		return fmt.Errorf("Failed to reset device: %s", err)
-	}
+const (
+	// Number of downloaded records to buffer before writing to the database
+	ImportBatchSize = 600
+)
 
-	count, err := device.GetSessionCount()
-	if err != nil {
-		return fmt.Errorf("Failed to reset device: %s", err)
-	}
+type ImportOptions struct {
+	// Dump data only. Don't save to database
+	Noop bool
 
-	log.Infof("Found %d sessions", count)
+	// Overwrite sessions that already exist in the database
+	Force bool
 
-	if count == 0 {
-		log.Warn("No sessions found. Nothing to import")
-		return nil
-	}
+	// Erase device memory after a successful import
+	EraseAfter bool
+
+	// Warn if the device clock differs from the host by more than this
+	MaxClockSkew time.Duration
+
+	// Device sessions to import numbered from 1. All sessions are imported
+	// if empty
+	Sessions []int
+
+	// Skip sessions that already exist in the database
+	NewOnly bool
+
+	// Prompt for the sessions to import
+	Interactive bool
+
+	// Number of times to download a session before giving up
+	DownloadAttempts int
+
+	// Save sessions even if fewer records are downloaded than the session
+	// duration requires
+	SkipVerify bool
+
+	// IANA time zone name or UTC offset the device clock was set to when the
+	// sessions were recorded. Defaults to the host time zone
+	TimeZone string
+
+	// Correct record times for the device clock drift since the clock was
+	// last set
+	DriftCorrect bool
+}
 
+// deviceSession is a session stored in the device memory
+type deviceSession struct {
+	index    uint8
+	duration time.Duration
+	interval time.Duration
+
+	// Start time read from the device clock and corrected for drift
+	deviceStart time.Time
+	start       time.Time
+	clock       *driftCorrection
+
+	// Matching session already in the database
+	existing *model.Session
+}
+
+// listSessions fetches the start time and duration of each session on the
+// device. If loc is set session start times are read as wall clock time in loc.
+// Sessions recorded since the clock was last set are corrected for drift if
+// clock is set. If checkDB is set sessions already in the database are looked
+// up.
+func listSessions(ctx context.Context, db model.Datastore, device device.Device, count uint8, loc *time.Location, clock *driftCorrection, checkDB bool) ([]*deviceSession, error) {
+	sessions := make([]*deviceSession, 0, count)
+	var imported []*model.Session
 	for i := uint8(0); i < count; i++ {
-		deviceModel, err := device.GetModel()
+		duration, err := device.GetSessionDuration(ctx, i)
 		if err != nil {
-			return fmt.Errorf("Failed to get device model: %s", err)
+			return nil, fmt.Errorf("Failed to fetch session duration: %s", err)
 		}
 
-		duration, err := device.GetSessionDuration(i)
+		startTime, err := device.GetSessionTime(ctx, i)
 		if err != nil {
-			return fmt.Errorf("Failed to fetch session duration: %s", err)
+			return nil, fmt.Errorf("Failed to fetch session time: %s", err)
+		}
+
+		if loc != nil {
+			startTime = model.InZone(startTime, loc)
 		}
 
-		startTime, err := device.GetSessionTime(i)
+		interval, err := device.GetSampleInterval(ctx, i)
 		if err != nil {
-			return fmt.Errorf("Failed to fetch session time: %s", err)
+			return nil, fmt.Errorf("Failed to fetch session sample interval: %s", err)
+		}
+		if interval < time.Second {
+			interval = time.Second
 		}
 
-		sessionID := int64(0)
+		ds := &deviceSession{index: i, deviceStart: startTime, duration: duration, interval: interval, clock: clock}
+		if clock != nil && startTime.Before(clock.set) {
+			log.Warnf("Session %d started before the device clock was last set. Not correcting clock drift", i+1)
+			ds.clock = nil
+		}
+		ds.start = ds.clock.correctStart(startTime)
 
-		if !noop {
-			session, err := db.FetchSessionByStartTime(startTime)
+		if checkDB {
+			session, err := db.FetchSessionByStartTime(ds.start)
 			if err == nil {
-				if !forceOverwrite {
-					return fmt.Errorf("Session already exists in database. Use --force to overwrite: %s", session)
-				}
-				sessionID = session.ID
-			} else if err == model.ErrNotFound {
-				session = &model.Session{StartTime: startTime, Model: deviceModel, Seconds: int(duration.Seconds())}
-				err := db.SaveSession(session)
-				if err != nil {
-					return fmt.Errorf("Failed to save session in database: %s", err)
-				}
-				sessionID = session.ID
+				ds.existing = session
+			} else if err != model.ErrNotFound {
+				return nil, fmt.Errorf("Failed to check for existing session in database: %s", err)
 			} else {
-				return fmt.Errorf("Failed to check for existing session in database: %s", err)
+				// Sessions imported earlier may have been corrected with
+				// the drift measured then
+				if imported == nil {
+					imported, err = db.FetchAllSessions()
+					if err != nil {
+						return nil, fmt.Errorf("Failed to check for existing session in database: %s", err)
+					}
+				}
+				ds.existing = findDeviceSession(imported, ds.deviceStart)
 			}
 		}
 
-		log.Infof("Importing data for session %d - %s (%s)", sessionID, startTime, duration)
-		data, err := device.GetSessionData(i)
-		if err != nil {
-			return fmt.Errorf("Failed to connect to device: %s", err)
+		sessions = append(sessions, ds)
+	}
+
+	return sessions, nil
+}
+
+// findDeviceSession returns the session recorded at device time start before
+// any drift correction or nil if not found
+func findDeviceSession(sessions []*model.Session, start time.Time) *model.Session {
+	for _, session := range sessions {
+		deviceStart := session.StartTime.Add(time.Duration(session.DriftCorrection) * time.Second)
+		if deviceStart.Equal(start) {
+			return session
 		}
+	}
 
-		log.Infof("Downloaded %d records. Total duration in seconds %0.2f", len(data), duration.Seconds())
+	return nil
+}
 
-		total := int(duration.Seconds())
-		if total > len(data) {
-			log.WithFields(log.Fields{
-				"numRecords":      len(data),
-				"durationSeconds": total,
-			}).Warn("Not enough records found for the session duration")
-			total = len(data)
+// appendSession appends ds to selected unless it was already selected
+func appendSession(selected []*deviceSession, ds *deviceSession) []*deviceSession {
+	for _, s := range selected {
+		if s == ds {
+			return selected
 		}
+	}
 
-		for i, rec := range data {
-			rec.SessionID = sessionID
-			rec.DateTime = startTime.Add(time.Second * time.Duration(i))
-			if noop {
-				fmt.Printf("Record %d - %s\n", i, rec)
-			} else {
-				log.Debugf("Record %d - %s", i, rec)
+	return append(selected, ds)
+}
+
+// selectSessions returns the sessions chosen with the Sessions or Interactive
+// options or all sessions
+func selectSessions(sessions []*deviceSession, opts *ImportOptions) ([]*deviceSession, error) {
+	if len(opts.Sessions) > 0 {
+		selected := make([]*deviceSession, 0, len(opts.Sessions))
+		for _, n := range opts.Sessions {
+			if n < 1 || n > len(sessions) {
+				return nil, fmt.Errorf("Invalid session %d. Device has %d sessions", n, len(sessions))
 			}
+			selected = appendSession(selected, sessions[n-1])
 		}
+		return selected, nil
+	}
+
+	if opts.Interactive {
+		return pickSessions(os.Stdin, os.Stdout, sessions)
+	}
 
-		if noop {
-			return nil
+	return sessions, nil
+}
+
+// recordCountError is returned when a download has fewer records than the
+// session duration requires
+type recordCountError struct {
+	count int
+	total int
+}
+
+func (e *recordCountError) Error() string {
+	return fmt.Sprintf("Downloaded %d records but the session duration needs %d", e.count, e.total)
+}
+
+// retryDownload returns true if a failed session download may succeed when
+// tried again
+func retryDownload(err error) bool {
+	_, mismatch := err.(*recordCountError)
+	return mismatch || device.IsTemporary(err)
+}
+
+// importSession downloads a session and saves it with its records in a single
+// transaction. Nothing is saved if the download fails or, unless skipVerify is
+// set, has fewer records than the session duration has sample intervals.
+func importSession(ctx context.Context, db model.Datastore, device device.Device, ds *deviceSession, session *model.Session, skipVerify bool) error {
+	tx, err := db.BeginImport()
+	if err != nil {
+		return fmt.Errorf("Failed to start database transaction: %s", err)
+	}
+
+	if session.ID == 0 {
+		err = tx.SaveSession(session)
+	} else {
+		// The drift correction may have changed so the old records would
+		// not all be replaced
+		err = tx.DeleteRecordsBySessionID(session.ID)
+		if err == nil {
+			err = tx.UpdateSession(session)
 		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to save session in database: %s", err)
+	}
+
+	duration := time.Duration(session.Seconds) * time.Second
+	log.Infof("Importing data for session %d - %s (%s, one sample every %s)", session.ID, session.StartTime, duration, session.Interval())
+
+	total := int(duration / session.Interval())
+	imp := &sessionImporter{
+		tx:        tx,
+		sessionID: session.ID,
+		startTime: ds.deviceStart,
+		clock:     ds.clock,
+		interval:  session.Interval(),
+		total:     total,
+		progress:  newProgressBar(total),
+	}
+
+	err = device.StreamSessionData(ctx, ds.index, imp.add)
+	if err == nil {
+		err = imp.flush()
+	}
+	imp.progress.Done()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	log.Infof("Downloaded %d records. Session duration needs %d", imp.count, imp.total)
+
+	if imp.count < imp.total {
+		if !skipVerify {
+			tx.Rollback()
+			return &recordCountError{count: imp.count, total: imp.total}
+		}
+
+		log.WithFields(log.Fields{
+			"numRecords":      imp.count,
+			"durationRecords": imp.total,
+		}).Warn("Not enough records found for the session duration")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Failed to commit session to database: %s", err)
+	}
+
+	return nil
+}
+
+// dumpSession prints the records of a session without saving them
+func dumpSession(ctx context.Context, device device.Device, ds *deviceSession) error {
+	log.Infof("Dumping data for session %s (%s, one sample every %s)", ds.start, ds.duration, ds.interval)
+
+	imp := &sessionImporter{noop: true, startTime: ds.deviceStart, clock: ds.clock, interval: ds.interval, total: int(ds.duration / ds.interval)}
+	err := device.StreamSessionData(ctx, ds.index, imp.add)
+	if err != nil {
+		return fmt.Errorf("Failed to download session data: %s", err)
+	}
+
+	log.Infof("Downloaded %d records. Total duration in seconds %0.2f", imp.count, ds.duration.Seconds())
+
+	return nil
+}
 
-		log.Infof("Saving records to database")
-		err = db.SaveRecords(data[:total])
+func Import(ctx context.Context, db model.Datastore, device device.Device, opts *ImportOptions) error {
+	var loc *time.Location
+	zone := model.LocalZoneName()
+	if len(opts.TimeZone) > 0 {
+		var err error
+		loc, err = model.LoadLocation(opts.TimeZone)
 		if err != nil {
-			return fmt.Errorf("Failed to save records to database: %s", err)
+			return err
+		}
+		zone = opts.TimeZone
+		log.Infof("Reading device times in time zone %s", zone)
+	}
+
+	err := device.ResetDevice(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to reset device: %s", err)
+	}
+
+	// The clock is only read for drift correction and the clock check. A
+	// failed read only fails the import if drift correction needs it.
+	var drift time.Duration
+	var measured time.Time
+	hasClock := false
+	if opts.DriftCorrect || opts.MaxClockSkew > 0 {
+		drift, measured, hasClock, err = measureDrift(ctx, device, loc)
+		if err != nil && opts.DriftCorrect {
+			return err
+		} else if err != nil {
+			warnClockError(err)
+		} else if hasClock {
+			warnSkew(drift, opts.MaxClockSkew)
+		}
+	}
+
+	count, err := device.GetSessionCount(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to reset device: %s", err)
+	}
+
+	log.Infof("Found %d sessions", count)
+
+	if count == 0 {
+		log.Warn("No sessions found. Nothing to import")
+		return nil
+	}
+
+	identity, err := device.GetIdentity(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get device identity: %s", err)
+	}
+
+	var clock *driftCorrection
+	if opts.DriftCorrect {
+		if hasClock {
+			clock, err = loadDriftCorrection(db, identity.DeviceID, measured, drift)
+			if err != nil {
+				return err
+			}
+		} else {
+			log.Warn("Device does not have a clock. Not correcting clock drift")
 		}
 	}
 
+	sessions, err := listSessions(ctx, db, device, count, loc, clock, !opts.Noop)
+	if err != nil {
+		return err
+	}
+
+	selected, err := selectSessions(sessions, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, ds := range selected {
+		if opts.Noop {
+			err := dumpSession(ctx, device, ds)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if ds.existing != nil {
+			if opts.NewOnly {
+				log.Infof("Skipping session %d already in database: %s", ds.index+1, ds.existing)
+				continue
+			}
+			if !opts.Force {
+				return fmt.Errorf("Session already exists in database. Use --force to overwrite or --new-only to skip: %s", ds.existing)
+			}
+		}
+
+		for attempt := 1; ; attempt++ {
+			session := ds.existing
+			if session == nil {
+				session = &model.Session{}
+				setSessionIdentity(session, identity)
+			}
+			session.StartTime = ds.start
+			session.Seconds = int(ds.duration.Seconds())
+			session.SampleInterval = int(ds.interval / time.Second)
+			session.TimeZone = zone
+			session.ClockDrift = int(drift.Seconds())
+			session.DriftCorrection = int(ds.deviceStart.Sub(ds.start).Seconds())
+
+			err = importSession(ctx, db, device, ds, session, opts.SkipVerify)
+			if err == nil {
+				ds.existing = session
+				break
+			}
+
+			if attempt >= opts.DownloadAttempts || !retryDownload(err) {
+				return fmt.Errorf("Failed to import session %s: %s", ds.start, err)
+			}
+
+			log.WithFields(log.Fields{
+				"error":   err,
+				"attempt": attempt,
+			}).Warn("Session download failed. Retrying")
+		}
+	}
+
+	if opts.EraseAfter && !opts.Noop {
+		// Verify the sessions as saved rather than reading the device
+		// times again, which may have been corrected for drift
+		return eraseSessions(ctx, db, device, sessions)
+	}
+
+	return nil
+}
+
+func setSessionIdentity(session *model.Session, identity *device.DeviceIdentity) {
+	session.Model = identity.Model
+	session.Vendor = identity.Vendor
+	session.DeviceID = identity.DeviceID
+	session.DeviceInfo = identity.Info
+}
+
+// sessionImporter saves records to the database in batches as they are
+// downloaded from the device
+type sessionImporter struct {
+	tx        model.ImportTx
+	noop      bool
+	sessionID int64
+	startTime time.Time
+	clock     *driftCorrection
+	interval  time.Duration
+	total     int
+	count     int
+	batch     []*model.OxiRecord
+	progress  *progressBar
+}
+
+func (s *sessionImporter) add(rec *model.OxiRecord) error {
+	i := s.count
+	s.count++
+
+	rec.SessionID = s.sessionID
+	rec.DateTime = s.clock.correct(s.startTime.Add(s.interval * time.Duration(i)))
+	if s.noop {
+		fmt.Printf("Record %d - %s\n", i, rec)
+		return nil
+	}
+
+	log.Debugf("Record %d - %s", i, rec)
+	s.progress.Update(s.count)
+
+	// Save at most one record per sample interval of the session duration
+	if i >= s.total {
+		return nil
+	}
+
+	s.batch = append(s.batch, rec)
+	if len(s.batch) >= ImportBatchSize {
+		return s.flush()
+	}
+
+	return nil
+}
+
+func (s *sessionImporter) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+
+	err := s.tx.SaveRecords(s.batch)
+	if err != nil {
+		return fmt.Errorf("Failed to save records to database: %s", err)
+	}
+
+	s.batch = s.batch[:0]
+
 	return nil
 }
@@ -0,0 +1,612 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aebruno/myoxi/device"
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestDB(t *testing.T) model.Datastore {
+	db, err := model.NewDB("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testing.Verbose() {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	return db
+}
+
+func newTestSimulator(t *testing.T, port string) device.Device {
+	sim := device.NewSimulator()
+	err := sim.Connect(context.Background(), port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sim
+}
+
+func TestImport(t *testing.T) {
+	db := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?sessions=2&duration=2h&seed=3")
+
+	err := Import(context.Background(), db, sim, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("Invalid number of sessions imported. Got %d wanted %d", len(sessions), 2)
+	}
+
+	for _, session := range sessions {
+		if session.DeviceID != "sim-3" || session.Model != "SIM" {
+			t.Errorf("Invalid device identity for imported session: %s", session)
+		}
+
+		if session.Seconds != 7200 {
+			t.Errorf("Invalid duration for imported session. Got %d wanted %d", session.Seconds, 7200)
+		}
+
+		count, err := db.CountRecordsBySessionID(session.ID)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if count != 7200 {
+			t.Errorf("Invalid number of records imported. Got %d wanted %d", count, 7200)
+		}
+	}
+
+	err = Import(context.Background(), db, sim, &ImportOptions{})
+	if err == nil {
+		t.Errorf("Expected error importing session that already exists")
+	}
+
+	err = Import(context.Background(), db, sim, &ImportOptions{Force: true, EraseAfter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := sim.GetSessionCount(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Invalid session count after import with erase. Got %d wanted %d", count, 0)
+	}
+}
+
+func TestImportSelectSessions(t *testing.T) {
+	db := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?sessions=3&duration=1h")
+
+	err := Import(context.Background(), db, sim, &ImportOptions{Sessions: []int{2, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start, err := sim.GetSessionTime(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 1 || !sessions[0].StartTime.Equal(start) {
+		t.Fatalf("Invalid sessions imported: %v", sessions)
+	}
+
+	err = Import(context.Background(), db, sim, &ImportOptions{Sessions: []int{4}})
+	if err == nil {
+		t.Errorf("Expected error importing session not on device")
+	}
+
+	err = Import(context.Background(), db, sim, &ImportOptions{})
+	if err == nil {
+		t.Errorf("Expected error importing session that already exists")
+	}
+
+	err = Import(context.Background(), db, sim, &ImportOptions{NewOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err = db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 3 {
+		t.Errorf("Invalid number of sessions imported. Got %d wanted %d", len(sessions), 3)
+	}
+}
+
+// flakyDevice fails the first failures session downloads part way through.
+// If short is set the download ends early instead of returning an error.
+type flakyDevice struct {
+	device.Device
+	failures int
+	short    bool
+}
+
+func (d *flakyDevice) StreamSessionData(ctx context.Context, session uint8, handler device.RecordHandler) error {
+	if d.failures == 0 {
+		return d.Device.StreamSessionData(ctx, session, handler)
+	}
+
+	d.failures--
+	count := 0
+	err := d.Device.StreamSessionData(ctx, session, func(rec *model.OxiRecord) error {
+		count++
+		if count > 100 {
+			return device.ErrTimeout
+		}
+		return handler(rec)
+	})
+	if err == device.ErrTimeout && d.short {
+		return nil
+	}
+
+	return err
+}
+
+func TestImportRetry(t *testing.T) {
+	for _, short := range []bool{false, true} {
+		db := newTestDB(t)
+		dev := &flakyDevice{Device: newTestSimulator(t, "sim://?duration=1h"), failures: 3, short: short}
+
+		err := Import(context.Background(), db, dev, &ImportOptions{DownloadAttempts: 3})
+		if err == nil {
+			t.Errorf("Expected error after failed downloads")
+		}
+
+		// Failed imports leave nothing behind
+		sessions, err := db.FetchAllSessions()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(sessions) != 0 {
+			t.Errorf("Invalid number of sessions after failed import. Got %d wanted %d", len(sessions), 0)
+		}
+
+		dev.failures = 2
+		err = Import(context.Background(), db, dev, &ImportOptions{DownloadAttempts: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		session, err := db.FetchLatestSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count, err := db.CountRecordsBySessionID(session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 3600 {
+			t.Errorf("Invalid number of records imported. Got %d wanted %d", count, 3600)
+		}
+	}
+}
+
+func TestImportSkipVerify(t *testing.T) {
+	db := newTestDB(t)
+	dev := &flakyDevice{Device: newTestSimulator(t, "sim://?duration=1h"), failures: 1, short: true}
+
+	err := Import(context.Background(), db, dev, &ImportOptions{SkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := db.FetchLatestSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := db.CountRecordsBySessionID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 100 {
+		t.Errorf("Invalid number of records imported. Got %d wanted %d", count, 100)
+	}
+}
+
+func TestImportSampleInterval(t *testing.T) {
+	db := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?duration=1h&interval=4s")
+
+	err := Import(context.Background(), db, sim, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := db.FetchLatestSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.SampleInterval != 4 || session.Seconds != 3600 {
+		t.Errorf("Invalid imported session. Got interval %d duration %d wanted interval %d duration %d", session.SampleInterval, session.Seconds, 4, 3600)
+	}
+
+	records, err := db.FetchRecordsBySessionID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 900 {
+		t.Fatalf("Invalid number of records imported. Got %d wanted %d", len(records), 900)
+	}
+
+	if records[1].DateTime.Sub(records[0].DateTime) != 4*time.Second {
+		t.Errorf("Invalid record timestamps. Got %s apart wanted %s", records[1].DateTime.Sub(records[0].DateTime), 4*time.Second)
+	}
+
+	err = Erase(context.Background(), db, sim, false, "")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportReplay(t *testing.T) {
+	db := newTestDB(t)
+
+	// Captured with the default import options
+	cms := &device.CMS50{}
+	err := cms.Connect(context.Background(), "file://../device/testdata/cms50f-import.cap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Import(context.Background(), db, cms, &ImportOptions{MaxClockSkew: 2 * time.Minute, DownloadAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := db.FetchLatestSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2018, time.November, 18, 0, 11, 39, 0, time.Local)
+	if !session.StartTime.Equal(start) || session.Model != "50F" || session.DeviceID != "0154321" {
+		t.Errorf("Invalid session. Got %s wanted start %s", session, start)
+	}
+
+	count, err := db.CountRecordsBySessionID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 15 {
+		t.Errorf("Invalid number of records imported. Got %d wanted %d", count, 15)
+	}
+}
+
+func TestImportClock(t *testing.T) {
+	db := newTestDB(t)
+	dev := &badClockDevice{Device: newTestSimulator(t, "sim://?duration=10m")}
+
+	// The clock is not read without a skew limit or drift correction
+	err := Import(context.Background(), db, dev, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dev.reads != 0 {
+		t.Errorf("Invalid number of clock reads. Got %d wanted %d", dev.reads, 0)
+	}
+
+	// A failed clock check is only a warning
+	err = Import(context.Background(), db, dev, &ImportOptions{MaxClockSkew: time.Minute, NewOnly: true})
+	if err != nil {
+		t.Errorf("Clock check should not fail import: %s", err)
+	}
+
+	if dev.reads != 1 {
+		t.Errorf("Invalid number of clock reads. Got %d wanted %d", dev.reads, 1)
+	}
+
+	// Drift correction can't be done without the clock
+	err = Import(context.Background(), db, dev, &ImportOptions{DriftCorrect: true, NewOnly: true})
+	if err == nil {
+		t.Errorf("Expected error correcting drift without the device clock")
+	}
+}
+
+func TestImportTimeZone(t *testing.T) {
+	db := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?duration=1h&start=2018-11-24T23:00:00Z")
+
+	err := Import(context.Background(), db, sim, &ImportOptions{TimeZone: "America/Denver"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := db.FetchLatestSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.TimeZone != "America/Denver" {
+		t.Errorf("Invalid session time zone. Got %s wanted %s", session.TimeZone, "America/Denver")
+	}
+
+	// The device clock reads 23:00 in Denver
+	start := session.StartTime.In(session.Location())
+	if start.Hour() != 23 || !start.Equal(time.Date(2018, time.November, 25, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("Invalid session start time. Got %s wanted %s", start, "2018-11-24 23:00:00 MST")
+	}
+
+	err = Import(context.Background(), db, sim, &ImportOptions{TimeZone: "Mars/Olympus"})
+	if err == nil {
+		t.Errorf("Expected error for unknown time zone")
+	}
+
+	// Sessions are found in the same zone when erasing
+	err = Erase(context.Background(), db, sim, false, "America/Denver")
+	if err != nil {
+		t.Error(err)
+	}
+
+	sim = newTestSimulator(t, "sim://?duration=1h&start=2018-11-26T23:00:00Z")
+	err = Import(context.Background(), db, sim, &ImportOptions{TimeZone: "America/Denver", EraseAfter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := sim.GetSessionCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Invalid session count after erase. Got %d wanted %d", count, 0)
+	}
+}
+
+func TestImportDriftCorrect(t *testing.T) {
+	db := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?duration=1h")
+
+	start, err := sim.GetSessionTime(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clock set a day before the session and ten minutes fast now
+	err = db.SaveClockSet(&model.ClockSet{DeviceID: "sim-1", SetTime: start.Add(-24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sim.SetTime(context.Background(), time.Now().Add(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Import(context.Background(), db, sim, &ImportOptions{DriftCorrect: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := db.FetchLatestSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ClockDrift != 600 {
+		t.Errorf("Invalid clock drift. Got %d wanted %d", session.ClockDrift, 600)
+	}
+
+	if session.DriftCorrection <= 0 || session.DriftCorrection >= 600 {
+		t.Errorf("Invalid drift correction. Got %d wanted between %d and %d", session.DriftCorrection, 0, 600)
+	}
+
+	if !session.StartTime.Equal(start.Add(-time.Duration(session.DriftCorrection) * time.Second)) {
+		t.Errorf("Invalid corrected start time. Got %s with correction %ds from %s", session.StartTime, session.DriftCorrection, start)
+	}
+
+	records, err := db.FetchRecordsBySessionID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3600 {
+		t.Fatalf("Invalid number of records imported. Got %d wanted %d", len(records), 3600)
+	}
+
+	// Later records are corrected more so the session is slightly shorter
+	span := records[len(records)-1].DateTime.Sub(records[0].DateTime)
+	if span >= time.Duration(len(records)-1)*time.Second {
+		t.Errorf("Invalid corrected record times. Got span %s wanted less than %s", span, time.Duration(len(records)-1)*time.Second)
+	}
+
+	// Running again finds the corrected session
+	err = Import(context.Background(), db, sim, &ImportOptions{DriftCorrect: true, NewOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 1 {
+		t.Errorf("Invalid number of sessions after import with drift correction. Got %d wanted %d", len(sessions), 1)
+	}
+
+	// Overwriting without drift correction moves the session back to the
+	// device start time and replaces all records
+	err = Import(context.Background(), db, sim, &ImportOptions{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err = db.FetchLatestSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !session.StartTime.Equal(start) || session.DriftCorrection != 0 {
+		t.Errorf("Invalid session after overwrite. Got %s with correction %ds wanted %s", session.StartTime, session.DriftCorrection, start)
+	}
+
+	count, err := db.CountRecordsBySessionID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 3600 {
+		t.Errorf("Invalid number of records after overwrite. Got %d wanted %d", count, 3600)
+	}
+
+	err = Import(context.Background(), db, sim, &ImportOptions{NewOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err = db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 1 {
+		t.Errorf("Invalid number of sessions after overwrite. Got %d wanted %d", len(sessions), 1)
+	}
+
+	// The drift corrected session just imported is erased
+	err = Import(context.Background(), db, sim, &ImportOptions{DriftCorrect: true, Force: true, EraseAfter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devCount, err := sim.GetSessionCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if devCount != 0 {
+		t.Errorf("Invalid session count after erase. Got %d wanted %d", devCount, 0)
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	db := newTestDB(t)
+	sim := newTestSimulator(t, "sim://")
+
+	err := SetClock(context.Background(), db, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock, err := db.FetchLastClockSet("sim-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(clock.SetTime) > time.Minute {
+		t.Errorf("Invalid clock set time. Got %s", clock.SetTime)
+	}
+}
+
+// badClockDevice fails to read its clock and reports a clock far ahead of the
+// host clock if fast is set
+type badClockDevice struct {
+	device.Device
+	fast  bool
+	reads int
+}
+
+func (d *badClockDevice) GetTime(ctx context.Context) (time.Time, error) {
+	d.reads++
+	if d.fast {
+		return time.Now().Add(time.Hour), nil
+	}
+
+	return time.Time{}, device.ErrTimeout
+}
+
+func TestDeviceInfo(t *testing.T) {
+	for _, fast := range []bool{false, true} {
+		dev := &badClockDevice{Device: newTestSimulator(t, "sim://?duration=10m"), fast: fast}
+
+		err := DeviceInfo(context.Background(), dev, time.Minute)
+		if err != nil {
+			t.Errorf("Clock check should not fail device info: %s", err)
+		}
+
+		if dev.reads != 1 {
+			t.Errorf("Invalid number of clock reads. Got %d wanted %d", dev.reads, 1)
+		}
+	}
+}
+
+func TestErase(t *testing.T) {
+	db := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?duration=1h")
+
+	err := Erase(context.Background(), db, sim, false, "")
+	if err == nil {
+		t.Errorf("Expected error erasing session that has not been imported")
+	}
+
+	err = Erase(context.Background(), db, sim, true, "")
+	if err != nil {
+		t.Error(err)
+	}
+
+	count, err := sim.GetSessionCount(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Invalid session count after forced erase. Got %d wanted %d", count, 0)
+	}
+}
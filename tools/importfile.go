@@ -0,0 +1,313 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aebruno/myoxi/loader"
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+type ImportFileOptions struct {
+	// File format. Chosen from the file extension if empty
+	Format string
+
+	// Print the sessions found without saving them to the database
+	Noop bool
+
+	// Overwrite sessions that already exist in the database
+	Force bool
+
+	// Skip sessions that already exist in the database
+	NewOnly bool
+}
+
+// Results of comparing the devices that recorded two sessions
+const (
+	deviceDifferent = iota
+	deviceUnknown
+	deviceSame
+)
+
+// compareDevices compares the vendor, model and device ID known for both
+// sessions. Sessions with none of these in common are the same device if they
+// were imported from the same file format, such as two OSCAR exports, and
+// unknown otherwise.
+func compareDevices(a, b *model.Session) int {
+	fields := [][2]string{
+		{a.Vendor, b.Vendor},
+		{a.Model, b.Model},
+		{a.DeviceID, b.DeviceID},
+	}
+
+	known := 0
+	for _, f := range fields {
+		if len(f[0]) == 0 || len(f[1]) == 0 {
+			continue
+		}
+		if !strings.EqualFold(f[0], f[1]) {
+			return deviceDifferent
+		}
+		known++
+	}
+
+	if known > 0 || (len(a.Source) > 0 && a.Source == b.Source) {
+		return deviceSame
+	}
+
+	return deviceUnknown
+}
+
+// overlaps returns true if the recordings of a and b overlap in time
+func overlaps(a, b *model.Session) bool {
+	aEnd := a.StartTime.Add(time.Duration(a.Seconds) * time.Second)
+	bEnd := b.StartTime.Add(time.Duration(b.Seconds) * time.Second)
+
+	return a.StartTime.Before(bEnd) && b.StartTime.Before(aEnd)
+}
+
+// contains returns true if b holds part of the recording of a: b was
+// imported from the same source, has the same sample interval and its samples
+// fall on those of a within the time a was recorded
+func contains(a, b *model.Session) bool {
+	aEnd := a.StartTime.Add(time.Duration(a.Seconds) * time.Second)
+	bEnd := b.StartTime.Add(time.Duration(b.Seconds) * time.Second)
+	offset := b.StartTime.Sub(a.StartTime)
+
+	return a.Source == b.Source && a.Interval() == b.Interval() &&
+		offset >= 0 && offset%a.Interval() == 0 && !bEnd.After(aEnd)
+}
+
+// findSession returns the session that duplicates s or nil if not found. A
+// session duplicates s if it was recorded by the same device at an
+// overlapping time, or starts at the same instant and was not recorded by a
+// different device. Software such as OSCAR can shift the start time of a
+// session so the same night may not start at the same time.
+func findSession(sessions []*model.Session, s *model.Session) *model.Session {
+	for _, session := range sessions {
+		if session.StartTime.Equal(s.StartTime) && compareDevices(session, s) != deviceDifferent {
+			return session
+		}
+	}
+
+	for _, session := range sessions {
+		if overlaps(session, s) && compareDevices(session, s) == deviceSame {
+			return session
+		}
+	}
+
+	return nil
+}
+
+// findOverlap returns a session overlapping s that may or may not have been
+// recorded by the same device or nil if not found
+func findOverlap(sessions []*model.Session, s *model.Session) *model.Session {
+	for _, session := range sessions {
+		if overlaps(session, s) && compareDevices(session, s) == deviceUnknown {
+			return session
+		}
+	}
+
+	return nil
+}
+
+// findStart returns the session starting at the same instant as s or nil if
+// not found
+func findStart(sessions []*model.Session, s *model.Session) *model.Session {
+	for _, session := range sessions {
+		if session.StartTime.Equal(s.StartTime) {
+			return session
+		}
+	}
+
+	return nil
+}
+
+// ImportFile reads the sessions stored in a file exported by myoxi or other
+// oximetry software and saves them to the database. Sessions that duplicate a
+// session already in the database, or overlap a session that may have been
+// recorded by the same device, are conflicts. They are reported and not saved
+// unless Force or NewOnly is set. Force overwrites duplicates and saves
+// possible duplicates as new sessions. A duplicate holding part of a session,
+// such as an export made with a start date, only overwrites the records it
+// holds.
+func ImportFile(db model.Datastore, path string, opts *ImportFileOptions) error {
+	var l loader.Loader
+	var err error
+	if len(opts.Format) > 0 {
+		l, err = loader.New(opts.Format)
+		if loader.Experimental(opts.Format) {
+			log.Warnf("The %s format is experimental and has only been tested with synthetic files. Check the imported sessions against the vendor software", opts.Format)
+		}
+	} else {
+		l, err = loader.ForFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sessions, err := l.Load(f)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %s", path, err)
+	}
+
+	log.Infof("Found %d sessions in %s", len(sessions), path)
+
+	existing, err := db.FetchAllSessions()
+	if err != nil {
+		return fmt.Errorf("Failed to fetch sessions from database: %s", err)
+	}
+
+	conflicts := 0
+	clashes := 0
+	for _, s := range sessions {
+		if len(s.Records) == 0 {
+			continue
+		}
+
+		partial := false
+		if other := findSession(existing, s.Session); other != nil {
+			if opts.NewOnly {
+				log.Infof("Skipping session already in database: %s", other)
+				continue
+			}
+			if !opts.Force {
+				log.WithFields(log.Fields{
+					"session":  s.StartTime,
+					"existing": other,
+				}).Warn("Session conflicts with a session already in the database")
+				conflicts++
+				continue
+			}
+			s.ID = other.ID
+			if contains(other, s.Session) {
+				s.StartTime = other.StartTime
+				s.Seconds = other.Seconds
+				partial = true
+			}
+		} else if other := findStart(existing, s.Session); other != nil {
+			// Session start times are unique
+			log.WithFields(log.Fields{
+				"session":  s.StartTime,
+				"existing": other,
+			}).Warn("Session starts at the same time as a session from another device")
+			clashes++
+			continue
+		} else if other := findOverlap(existing, s.Session); other != nil {
+			if opts.NewOnly {
+				log.Infof("Skipping session overlapping a session from an unknown device: %s", other)
+				continue
+			}
+			if !opts.Force {
+				log.WithFields(log.Fields{
+					"session":  s.StartTime,
+					"existing": other,
+				}).Warn("Session overlaps a session that may be from the same device")
+				conflicts++
+				continue
+			}
+			log.Infof("Saving session overlapping %s as a new session", other)
+		}
+
+		if opts.Noop {
+			log.Infof("Session %s", s)
+			continue
+		}
+
+		err := saveFileSession(db, s, partial)
+		if err != nil {
+			return fmt.Errorf("Failed to import session %s: %s", s.StartTime, err)
+		}
+
+		existing = append(existing, s.Session)
+	}
+
+	if clashes > 0 {
+		return fmt.Errorf("%d sessions start at the same time as a session from another device and were not imported", clashes)
+	}
+
+	if conflicts > 0 {
+		return fmt.Errorf("%d sessions conflict with sessions already in the database. Use --force to overwrite or --new-only to skip", conflicts)
+	}
+
+	return nil
+}
+
+// saveFileSession saves a session and its records in a single transaction.
+// The records of an overwritten session are removed unless partial is set.
+func saveFileSession(db model.Datastore, s *loader.Session, partial bool) error {
+	tx, err := db.BeginImport()
+	if err != nil {
+		return fmt.Errorf("Failed to start database transaction: %s", err)
+	}
+
+	if s.ID == 0 {
+		err = tx.SaveSession(s.Session)
+	} else if partial {
+		err = tx.UpdateSession(s.Session)
+	} else {
+		// The overwritten session may have started at another time so
+		// its records would not all be replaced
+		err = tx.DeleteRecordsBySessionID(s.ID)
+		if err == nil {
+			err = tx.UpdateSession(s.Session)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to save session in database: %s", err)
+	}
+
+	log.Infof("Importing %d records for session %d - %s", len(s.Records), s.ID, s.StartTime)
+
+	for i := 0; i < len(s.Records); i += ImportBatchSize {
+		end := i + ImportBatchSize
+		if end > len(s.Records) {
+			end = len(s.Records)
+		}
+
+		batch := s.Records[i:end]
+		for _, rec := range batch {
+			rec.SessionID = s.ID
+		}
+
+		err := tx.SaveRecords(batch)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to save records to database: %s", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Failed to commit session to database: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,523 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+func TestImportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "myoxi-import-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?sessions=2&duration=30m&interval=2s")
+
+	err = Import(context.Background(), src, sim, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range ExportFormats {
+		path := filepath.Join(dir, "export."+format)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = Export(src, &model.RecordQuery{}, format, f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := newTestDB(t)
+		err = ImportFile(db, path, &ImportFileOptions{})
+		if err != nil {
+			t.Fatalf("Failed to import %s: %s", path, err)
+		}
+
+		sessions, err := db.FetchAllSessions()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(sessions) != 2 {
+			t.Fatalf("Invalid number of sessions imported from %s. Got %d wanted %d", format, len(sessions), 2)
+		}
+
+		for _, session := range sessions {
+			if session.SampleInterval != 2 || session.Seconds != 1800 {
+				t.Errorf("Invalid session imported from %s. Got interval %d duration %d wanted %d %d", format, session.SampleInterval, session.Seconds, 2, 1800)
+			}
+
+			count, err := db.CountRecordsBySessionID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if count != 900 {
+				t.Errorf("Invalid number of records imported from %s. Got %d wanted %d", format, count, 900)
+			}
+		}
+	}
+
+	// Importing into the source database conflicts with every session
+	path := filepath.Join(dir, "export.csv")
+	err = ImportFile(src, path, &ImportFileOptions{})
+	if err == nil {
+		t.Errorf("Expected error importing sessions that conflict with existing sessions")
+	}
+
+	err = ImportFile(src, path, &ImportFileOptions{NewOnly: true})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = ImportFile(src, path, &ImportFileOptions{Force: true})
+	if err != nil {
+		t.Error(err)
+	}
+
+	sessions, err := src.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 {
+		t.Errorf("Invalid number of sessions after importing conflicts. Got %d wanted %d", len(sessions), 2)
+	}
+
+	err = ImportFile(src, filepath.Join(dir, "export.xyz"), &ImportFileOptions{})
+	if err == nil {
+		t.Errorf("Expected error for unknown file format")
+	}
+}
+
+func TestImportFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "myoxi-import-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := newTestDB(t)
+	sim := newTestSimulator(t, "sim://?sessions=2&duration=30m&interval=2s")
+
+	err = Import(context.Background(), src, sim, &ImportOptions{TimeZone: "America/Denver"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := src.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Partial exports start part way through the first session
+	from := original[0].StartTime.Add(10 * time.Minute)
+
+	for _, format := range ExportFormats {
+		for _, query := range []*model.RecordQuery{{}, {From: from}} {
+			path := filepath.Join(dir, "export."+format)
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Export(src, query, format, f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Re-imported sessions match the sessions they were exported from
+			err = ImportFile(src, path, &ImportFileOptions{})
+			if err == nil {
+				t.Errorf("Expected error importing %s export from %s into the source database", format, query.From)
+			}
+
+			err = ImportFile(src, path, &ImportFileOptions{Force: true})
+			if err != nil {
+				t.Fatalf("Failed to import %s export from %s: %s", format, query.From, err)
+			}
+
+			sessions, err := src.FetchAllSessions()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sessions) != 2 {
+				t.Fatalf("Invalid number of sessions after importing %s export from %s. Got %d wanted %d", format, query.From, len(sessions), 2)
+			}
+
+			for i, session := range sessions {
+				if session.String() != original[i].String() || session.TimeZone != "America/Denver" || session.DeviceInfo != original[i].DeviceInfo {
+					t.Errorf("Invalid session after importing %s export from %s. Got %s wanted %s", format, query.From, session, original[i])
+				}
+
+				count, err := src.CountRecordsBySessionID(session.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if count != 900 {
+					t.Errorf("Invalid number of records after importing %s export from %s. Got %d wanted %d", format, query.From, count, 900)
+				}
+			}
+		}
+
+		// Sessions imported into another database keep their details
+		path := filepath.Join(dir, "export."+format)
+		db := newTestDB(t)
+		err = ImportFile(db, path, &ImportFileOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sessions, err := db.FetchAllSessions()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(sessions) != 2 || sessions[1].String() != original[1].String() || sessions[1].TimeZone != "America/Denver" || sessions[1].Vendor != original[1].Vendor {
+			t.Errorf("Invalid sessions imported from %s into another database. Got %v wanted %v", format, sessions, original)
+		}
+	}
+}
+
+func TestImportFileContec(t *testing.T) {
+	db := newTestDB(t)
+
+	path := filepath.Join("..", "loader", "testdata", "contec-export.csv")
+	err := ImportFile(db, path, &ImportFileOptions{Format: "contec-csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ImportFile(db, filepath.Join("..", "loader", "testdata", "contec-night.SpO2"), &ImportFileOptions{Format: "contec-spo2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("Invalid number of sessions. Got %d wanted %d", len(sessions), 2)
+	}
+
+	for _, session := range sessions {
+		if session.Vendor != "CONTEC" || (session.Source != "contec-csv" && session.Source != "contec-spo2") {
+			t.Errorf("Invalid session source. Got %s %s", session.Vendor, session.Source)
+		}
+	}
+
+	start := time.Date(2018, time.November, 28, 23, 59, 56, 0, time.Local)
+	if !sessions[0].StartTime.Equal(start) || sessions[0].Model != "CMS50E" {
+		t.Errorf("Invalid session. Got %s wanted start %s", sessions[0], start)
+	}
+
+	count, err := db.CountRecordsBySessionID(sessions[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 8 {
+		t.Errorf("Invalid number of records. Got %d wanted %d", count, 8)
+	}
+}
+
+func TestImportFileO2Ring(t *testing.T) {
+	db := newTestDB(t)
+
+	// A finger clip night and a ring night analysed together
+	sim := newTestSimulator(t, "sim://?sessions=1&duration=10m")
+	err := Import(context.Background(), db, sim, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ImportFile(db, filepath.Join("..", "loader", "testdata", "o2ring-night.dat"), &ImportFileOptions{Format: "o2ring"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring := findSession(sessions, &model.Session{StartTime: time.Date(2019, time.March, 2, 23, 41, 8, 0, time.Local)})
+	if ring == nil || ring.Model != "O2Ring" || ring.SampleInterval != 4 {
+		t.Fatalf("Invalid O2Ring session. Got %v", ring)
+	}
+
+	records, err := db.FetchRecordsBySessionID(ring.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 10 || records[3].Motion != 40 {
+		t.Errorf("Invalid O2Ring records. Got %d records wanted %d with motion", len(records), 10)
+	}
+
+	// Motion survives an export and import in every format
+	dir, err := ioutil.TempDir("", "myoxi-import-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, format := range ExportFormats {
+		path := filepath.Join(dir, "ring."+format)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = Export(db, &model.RecordQuery{SessionID: ring.ID}, format, f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dst := newTestDB(t)
+		err = ImportFile(dst, path, &ImportFileOptions{})
+		if err != nil {
+			t.Fatalf("Failed to import %s: %s", path, err)
+		}
+
+		imported, err := dst.FetchRecords(time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(imported) != 10 || imported[3].Motion != 40 {
+			t.Errorf("Invalid records imported from %s. Got %d records wanted %d with motion", format, len(imported), 10)
+		}
+	}
+
+	all, err := db.FetchRecords(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := ComputeStats(all, sessions)
+	if stats.totalRecords+stats.badRecords != len(all) {
+		t.Errorf("Invalid stats. Got %d records %d bad wanted %d records", stats.totalRecords, stats.badRecords, len(all))
+	}
+
+	stats = ComputeStats(records, []*model.Session{ring})
+	if stats.badRecords != 2 || stats.spo2Min != 88 {
+		t.Errorf("Invalid O2Ring stats. Got %d bad records min spo2 %d wanted %d and %d", stats.badRecords, stats.spo2Min, 2, 88)
+	}
+}
+
+func TestImportFileOSCAR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "myoxi-import-oscar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := newTestDB(t)
+	opts := &ImportFileOptions{Format: "oscar"}
+
+	err = ImportFile(db, filepath.Join("..", "loader", "testdata", "oscar-details.csv"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same nights with the times shifted by a second in OSCAR
+	data, err := ioutil.ReadFile(filepath.Join("..", "loader", "testdata", "sleepyhead-details.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shifted := string(data)
+	for _, shift := range [][2]string{
+		{"23:00:03", "23:00:04"}, {"23:00:02", "23:00:03"}, {"23:00:01", "23:00:02"}, {"23:00:00", "23:00:01"},
+		{"01:15:34", "01:15:35"}, {"01:15:32", "01:15:33"}, {"01:15:30", "01:15:31"},
+	} {
+		shifted = strings.Replace(shifted, shift[0], shift[1], -1)
+	}
+
+	path := filepath.Join(dir, "shifted.csv")
+	err = ioutil.WriteFile(path, []byte(shifted), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ImportFile(db, path, opts)
+	if err == nil {
+		t.Errorf("Expected error importing shifted sessions from the same device")
+	}
+
+	err = ImportFile(db, path, &ImportFileOptions{Format: "oscar", Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("Invalid number of sessions. Got %d wanted %d", len(sessions), 2)
+	}
+
+	start := time.Date(2018, time.November, 24, 23, 0, 1, 0, time.Local)
+	if !sessions[0].StartTime.Equal(start) || sessions[0].Source != "oscar" {
+		t.Errorf("Invalid session. Got %s wanted start %s", sessions[0], start)
+	}
+
+	// Overwritten sessions have no records left from the old start time
+	count, err := db.CountRecordsBySessionID(sessions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 4 {
+		t.Errorf("Invalid number of records. Got %d wanted %d", count, 4)
+	}
+}
+
+func TestFindSession(t *testing.T) {
+	start := time.Now()
+	ring := &model.Session{StartTime: start, Seconds: 3600, Vendor: "Viatom", Model: "O2Ring"}
+	clip := &model.Session{StartTime: start.Add(time.Minute), Seconds: 3600, Vendor: "CONTEC", Model: "50F"}
+	file := &model.Session{StartTime: start.Add(time.Minute), Seconds: 600}
+
+	if findSession([]*model.Session{ring}, clip) != nil {
+		t.Errorf("Overlapping sessions from different devices should not be duplicates")
+	}
+
+	if findSession([]*model.Session{ring}, file) != nil {
+		t.Errorf("Overlapping session without device details should not be a duplicate")
+	}
+
+	if findOverlap([]*model.Session{ring}, file) != ring || findOverlap([]*model.Session{ring}, clip) != nil {
+		t.Errorf("Only overlapping sessions without device details should be possible duplicates")
+	}
+
+	oscar := &model.Session{StartTime: start.Add(time.Minute), Seconds: 600, Source: "oscar"}
+	shifted := &model.Session{StartTime: start.Add(2 * time.Minute), Seconds: 600, Source: "oscar"}
+	if findSession([]*model.Session{oscar}, shifted) != oscar {
+		t.Errorf("Overlapping sessions from the same file format should be duplicates")
+	}
+
+	later := &model.Session{StartTime: start.Add(2 * time.Hour), Seconds: 600, Vendor: "Viatom", Model: "O2Ring"}
+	if findSession([]*model.Session{ring}, later) != nil {
+		t.Errorf("Sessions that do not overlap should not be duplicates")
+	}
+
+	same := &model.Session{StartTime: start, Seconds: 60, Vendor: "CONTEC", Model: "50F"}
+	if findSession([]*model.Session{ring}, same) != nil {
+		t.Errorf("Sessions from different devices starting at the same time should not be duplicates")
+	}
+
+	same = &model.Session{StartTime: start, Seconds: 60}
+	if findSession([]*model.Session{ring}, same) != ring {
+		t.Errorf("Session without device details starting at the same time should be a duplicate")
+	}
+}
+
+func TestImportFileUnknownDevice(t *testing.T) {
+	db := newTestDB(t)
+
+	// A night from the finger clip and the same night from OSCAR, which
+	// could have come from another oximeter
+	start := time.Date(2018, time.November, 24, 22, 30, 0, 0, time.Local)
+	err := db.SaveSession(&model.Session{StartTime: start, Seconds: 3600, Vendor: "CONTEC", Model: "50F"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join("..", "loader", "testdata", "oscar-details.csv")
+	err = ImportFile(db, path, &ImportFileOptions{Format: "oscar"})
+	if err == nil {
+		t.Errorf("Expected error importing a session overlapping a session from an unknown device")
+	}
+
+	err = ImportFile(db, path, &ImportFileOptions{Format: "oscar", NewOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("Invalid number of sessions after skipping. Got %d wanted %d", len(sessions), 2)
+	}
+
+	// The clip session is kept
+	err = ImportFile(db, path, &ImportFileOptions{Format: "oscar", Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err = db.FetchAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 3 || sessions[0].Model != "50F" || sessions[1].Source != "oscar" {
+		t.Fatalf("Invalid sessions after forcing. Got %v", sessions)
+	}
+
+	// A session from another device starting at the same time can't be saved
+	err = db.SaveSession(&model.Session{StartTime: time.Date(2018, time.November, 27, 1, 0, 0, 0, time.Local), Seconds: 60, Vendor: "Viatom", Model: "O2Ring"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contec := "Model,CMS50E\nStart Time,2018-11-27 01:00:00\n\nTime,SpO2(%),PR(bpm)\n01:00:00,95,64\n01:00:02,95,65\n"
+	dir, err := ioutil.TempDir("", "myoxi-import-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path = filepath.Join(dir, "contec.csv")
+	err = ioutil.WriteFile(path, []byte(contec), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ImportFile(db, path, &ImportFileOptions{Format: "contec-csv", Force: true})
+	if err == nil {
+		t.Errorf("Expected error importing a session starting at the same time as a session from another device")
+	}
+}
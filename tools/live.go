@@ -0,0 +1,215 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aebruno/myoxi/device"
+	"github.com/aebruno/myoxi/model"
+	. "github.com/logrusorgru/aurora"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Number of live records to buffer before writing to the database
+	LiveBatchSize = 60
+
+	// Number of waveform samples to buffer before writing to the database
+	WaveformBatchSize = 3600
+)
+
+// liveRecorder saves live records to the database in batches. The session
+// duration is updated with each batch so the session remains consistent with
+// the records written so far if the process dies.
+type liveRecorder struct {
+	db       model.Datastore
+	identity *device.DeviceIdentity
+	session  *model.Session
+	batch    []*model.OxiRecord
+	waveform []*model.WaveformSample
+	lastTime time.Time
+}
+
+func (r *liveRecorder) startSession(start time.Time) error {
+	if r.session != nil {
+		return nil
+	}
+
+	r.session = &model.Session{StartTime: start}
+	setSessionIdentity(r.session, r.identity)
+	err := r.db.SaveSession(r.session)
+	if err != nil {
+		return fmt.Errorf("Failed to save session in database: %s", err)
+	}
+
+	log.Infof("Recording live data to session %d", r.session.ID)
+
+	return nil
+}
+
+func (r *liveRecorder) add(rec *model.OxiRecord) error {
+	err := r.startSession(rec.DateTime)
+	if err != nil {
+		return err
+	}
+
+	r.lastTime = rec.DateTime
+
+	// Finger out. Leave a gap in the records rather than saving zeros
+	if rec.Status.Has(model.StatusFingerOut) {
+		return nil
+	}
+
+	rec.SessionID = r.session.ID
+	r.batch = append(r.batch, rec)
+
+	if len(r.batch) >= LiveBatchSize {
+		return r.flush()
+	}
+
+	return nil
+}
+
+func (r *liveRecorder) addWaveform(sample *model.WaveformSample) error {
+	err := r.startSession(sample.DateTime)
+	if err != nil {
+		return err
+	}
+
+	sample.SessionID = r.session.ID
+	r.waveform = append(r.waveform, sample)
+
+	if len(r.waveform) >= WaveformBatchSize {
+		return r.flushWaveform()
+	}
+
+	return nil
+}
+
+func (r *liveRecorder) flushWaveform() error {
+	if len(r.waveform) == 0 {
+		return nil
+	}
+
+	err := r.db.SaveWaveform(r.waveform)
+	if err != nil {
+		return fmt.Errorf("Failed to save waveform to database: %s", err)
+	}
+
+	log.Debugf("Saved %d waveform samples to session %d", len(r.waveform), r.session.ID)
+	r.waveform = r.waveform[:0]
+
+	return nil
+}
+
+func (r *liveRecorder) flush() error {
+	if r.session == nil {
+		return nil
+	}
+
+	err := r.flushWaveform()
+	if err != nil {
+		return err
+	}
+
+	if len(r.batch) > 0 {
+		err := r.db.SaveRecords(r.batch)
+		if err != nil {
+			return fmt.Errorf("Failed to save records to database: %s", err)
+		}
+		log.Debugf("Saved %d live records to session %d", len(r.batch), r.session.ID)
+		r.batch = r.batch[:0]
+	}
+
+	r.session.Seconds = int(r.lastTime.Sub(r.session.StartTime).Seconds()) + 1
+	err = r.db.UpdateSession(r.session)
+	if err != nil {
+		return fmt.Errorf("Failed to update session in database: %s", err)
+	}
+
+	return nil
+}
+
+// Live streams live data from device until ctx is done
+func Live(ctx context.Context, db model.Datastore, device device.Device, record, waveform bool) error {
+	if waveform && !record {
+		return fmt.Errorf("Waveform can only be saved when recording live data")
+	}
+
+	err := device.ResetDevice(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to reset device: %s", err)
+	}
+
+	var recorder *liveRecorder
+	if record {
+		identity, err := device.GetIdentity(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to get device identity: %s", err)
+		}
+
+		recorder = &liveRecorder{db: db, identity: identity}
+	}
+
+	log.Info("Streaming live data from device. Press Ctrl-C to stop")
+
+	var waveformHandler func(sample *model.WaveformSample) error
+	if recorder != nil && waveform {
+		waveformHandler = recorder.addWaveform
+	}
+
+	err = device.StreamLiveData(ctx, func(rec *model.OxiRecord) error {
+		printLiveRecord(rec)
+		if recorder != nil {
+			return recorder.add(rec)
+		}
+		return nil
+	}, waveformHandler)
+
+	if recorder != nil {
+		ferr := recorder.flush()
+		if ferr != nil {
+			log.Error(ferr)
+		} else if recorder.session != nil {
+			log.Infof("Recorded session %d", recorder.session.ID)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to stream live data: %s", err)
+	}
+
+	return nil
+}
+
+func printLiveRecord(rec *model.OxiRecord) {
+	if rec.Status.Has(model.StatusFingerOut) {
+		fmt.Printf("%s  finger out\n", rec.DateTime.Format("15:04:05"))
+		return
+	}
+
+	if !rec.Valid() {
+		fmt.Printf("%s  SpO2 %%: %3d  Pulse Rate: %3d  (%s)\n", rec.DateTime.Format("15:04:05"), rec.Spo2, rec.Pulse, rec.Status)
+		return
+	}
+
+	fmt.Printf("%s  SpO2 %%: %3d  Pulse Rate: %3d\n", rec.DateTime.Format("15:04:05"), Bold(Blue(rec.Spo2)), Bold(Red(rec.Pulse)))
+}
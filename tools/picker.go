@@ -0,0 +1,109 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// pickSessions lists the device sessions on out and reads the sessions to
+// import from in. The answer is a list of session numbers or ranges such as
+// "1,3-5", "all", "none" or empty for the sessions not yet in the database.
+func pickSessions(in io.Reader, out io.Writer, sessions []*deviceSession) ([]*deviceSession, error) {
+	fmt.Fprintf(out, "Sessions on device:\n")
+	for i, ds := range sessions {
+		status := "new"
+		if ds.existing != nil {
+			status = fmt.Sprintf("imported as session %d", ds.existing.ID)
+		}
+		fmt.Fprintf(out, "  %2d) %s (%s) %s\n", i+1, ds.start.Format("2006-01-02 15:04:05"), ds.duration, status)
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprintf(out, "Sessions to import (e.g. 1,3-5, all or none) [new]: ")
+
+		answer, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || len(answer) == 0) {
+			return nil, fmt.Errorf("Failed to read session selection: %s", err)
+		}
+
+		selected, perr := parseSessionSelection(strings.TrimSpace(answer), sessions)
+		if perr == nil {
+			return selected, nil
+		}
+
+		fmt.Fprintf(out, "%s\n", perr)
+		if err == io.EOF {
+			return nil, perr
+		}
+	}
+}
+
+func parseSessionSelection(answer string, sessions []*deviceSession) ([]*deviceSession, error) {
+	selected := make([]*deviceSession, 0, len(sessions))
+
+	switch strings.ToLower(answer) {
+	case "":
+		for _, ds := range sessions {
+			if ds.existing == nil {
+				selected = append(selected, ds)
+			}
+		}
+		return selected, nil
+	case "all":
+		return sessions, nil
+	case "none":
+		return selected, nil
+	}
+
+	fields := strings.FieldsFunc(answer, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+
+	for _, field := range fields {
+		first, last := field, field
+		if i := strings.Index(field, "-"); i > 0 {
+			first, last = field[:i], field[i+1:]
+		}
+
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid session number: %s", field)
+		}
+
+		to, err := strconv.Atoi(last)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid session number: %s", field)
+		}
+
+		if from < 1 || to > len(sessions) || from > to {
+			return nil, fmt.Errorf("Invalid session %s. Device has %d sessions", field, len(sessions))
+		}
+
+		for n := from; n <= to; n++ {
+			selected = appendSession(selected, sessions[n-1])
+		}
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+func TestPickSessions(t *testing.T) {
+	start := time.Date(2018, time.November, 24, 0, 23, 46, 0, time.Local)
+	sessions := make([]*deviceSession, 0)
+	for i := 0; i < 5; i++ {
+		sessions = append(sessions, &deviceSession{
+			index:    uint8(i),
+			start:    start.AddDate(0, 0, i),
+			duration: 7 * time.Hour,
+		})
+	}
+	sessions[1].existing = &model.Session{ID: 12}
+
+	tests := []struct {
+		answer string
+		valid  []uint8
+	}{
+		{"\n", []uint8{0, 2, 3, 4}},
+		{"all\n", []uint8{0, 1, 2, 3, 4}},
+		{"none\n", []uint8{}},
+		{"1,3-5\n", []uint8{0, 2, 3, 4}},
+		{"2 4", []uint8{1, 3}},
+		{"9\n2\n", []uint8{1}},
+		{"3,1,1-3\n", []uint8{2, 0, 1}},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		selected, err := pickSessions(strings.NewReader(test.answer), &out, sessions)
+		if err != nil {
+			t.Errorf("Failed to pick sessions for %q: %s", test.answer, err)
+			continue
+		}
+
+		got := make([]uint8, 0)
+		for _, ds := range selected {
+			got = append(got, ds.index)
+		}
+
+		if !bytes.Equal(got, test.valid) {
+			t.Errorf("Invalid sessions for %q: got '%v' should be '%v'", test.answer, got, test.valid)
+		}
+
+		if !strings.Contains(out.String(), " 2) 2018-11-25 00:23:46 (7h0m0s) imported as session 12") {
+			t.Errorf("Invalid session list: %s", out.String())
+		}
+	}
+
+	_, err := pickSessions(strings.NewReader("x"), &bytes.Buffer{}, sessions)
+	if err == nil {
+		t.Errorf("Expected error for invalid session selection")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Width of the progress bar in characters
+	ProgressBarWidth = 30
+
+	// Minimum time between progress bar redraws
+	ProgressInterval = 100 * time.Millisecond
+)
+
+// progressBar prints the percent complete and estimated time remaining of a
+// download. Nothing is printed unless out is a terminal.
+type progressBar struct {
+	out   io.Writer
+	total int
+	count int
+	start time.Time
+	last  time.Time
+}
+
+func newProgressBar(total int) *progressBar {
+	p := &progressBar{total: total, start: time.Now()}
+
+	stat, err := os.Stderr.Stat()
+	if err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		p.out = os.Stderr
+	}
+
+	return p
+}
+
+// Update sets the number of items completed and redraws the progress bar
+func (p *progressBar) Update(count int) {
+	p.count = count
+	if p.out == nil || time.Since(p.last) < ProgressInterval {
+		return
+	}
+
+	p.last = time.Now()
+	fmt.Fprintf(p.out, "\r%s", p.render(count, time.Since(p.start)))
+}
+
+// Done draws the final progress bar and ends the line
+func (p *progressBar) Done() {
+	if p.out == nil {
+		return
+	}
+
+	fmt.Fprintf(p.out, "\r%s\n", p.render(p.count, time.Since(p.start)))
+}
+
+func (p *progressBar) render(count int, elapsed time.Duration) string {
+	fraction := 1.0
+	if p.total > 0 && count < p.total {
+		fraction = float64(count) / float64(p.total)
+	}
+
+	filled := int(fraction * ProgressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", ProgressBarWidth-filled)
+
+	eta := "--"
+	if count > 0 && fraction < 1 {
+		remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		eta = remaining.Round(time.Second).String()
+	} else if fraction >= 1 {
+		eta = "0s"
+	}
+
+	return fmt.Sprintf("[%s] %3d%% %d/%d ETA %s ", bar, int(fraction*100), count, p.total, eta)
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressBar(t *testing.T) {
+	p := &progressBar{total: 200}
+
+	tests := []struct {
+		count   int
+		elapsed time.Duration
+		valid   string
+	}{
+		{0, 0, "[                              ]   0% 0/200 ETA -- "},
+		{50, 10 * time.Second, "[=======                       ]  25% 50/200 ETA 30s "},
+		{200, 40 * time.Second, "[==============================] 100% 200/200 ETA 0s "},
+		{210, 40 * time.Second, "[==============================] 100% 210/200 ETA 0s "},
+	}
+
+	for _, test := range tests {
+		bar := p.render(test.count, test.elapsed)
+		if bar != test.valid {
+			t.Errorf("Invalid progress bar: got '%s' should be '%s'", bar, test.valid)
+		}
+	}
+
+	// Progress bars not attached to a terminal print nothing
+	p.Update(10)
+	p.Done()
+}
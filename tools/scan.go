@@ -0,0 +1,57 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aebruno/myoxi/device"
+)
+
+// ScanPorts lists candidate serial ports and probes each for a device using
+// driver
+func ScanPorts(ctx context.Context, driver string) error {
+	ports, err := device.ListPorts()
+	if err != nil {
+		return fmt.Errorf("Failed to list serial ports: %s", err)
+	}
+
+	if len(ports) == 0 {
+		fmt.Printf("No serial ports found. Is the device plugged in?\n")
+		return nil
+	}
+
+	for _, port := range ports {
+		dev, err := device.New(driver)
+		if err != nil {
+			return err
+		}
+
+		err = device.Probe(ctx, dev, port.Path)
+		if err != nil {
+			fmt.Printf("- %s: no %s device (%s)\n", port, driver, err)
+			continue
+		}
+
+		fmt.Printf("- %s: found %s device\n", port, driver)
+		dev.Close()
+	}
+
+	return nil
+}
@@ -59,78 +59,100 @@ func (d *DesaturationEvent) String() string {
 	return fmt.Sprintf("%s lasting %s desaturation %.2f to %.2f", d.start.Format("01-02 15:04:05"), d.end.Sub(d.start), d.avg120, mean)
 }
 
-func computeODI(data []*model.OxiRecord) (float64, time.Duration, []*DesaturationEvent) {
+// sampleIntervals maps session IDs to the time between samples
+func sampleIntervals(sessions []*model.Session) map[int64]time.Duration {
+	intervals := make(map[int64]time.Duration, len(sessions))
+	for _, session := range sessions {
+		intervals[session.ID] = session.Interval()
+	}
+
+	return intervals
+}
+
+// recordInterval returns the sample interval of the session a record belongs
+// to. Records from unknown sessions are assumed to be one second apart.
+func recordInterval(rec *model.OxiRecord, intervals map[int64]time.Duration) time.Duration {
+	if interval, ok := intervals[rec.SessionID]; ok {
+		return interval
+	}
+
+	return time.Second
+}
+
+// computeODI returns the oxygen desaturation index, the time spent below 90%
+// SpO2 and the desaturation events. Each sample counts for the sample interval
+// of its session so hours and the 120 second baseline are measured in time
+// rather than in number of samples.
+func computeODI(data []*model.OxiRecord, intervals map[int64]time.Duration) (float64, time.Duration, []*DesaturationEvent) {
 	nullTime := time.Time{}
 	avg120 := float64(95)
 	sum120 := 0
-	count120 := 1
+	count120 := 0
+	var window120 time.Duration
 
-	sumODI := 0
+	sumODI := float64(0)
 	countODI := 0
+	hourODI := float64(0)
+	var hour time.Duration
 
 	events := make([]*DesaturationEvent, 0)
 	curEvent := &DesaturationEvent{}
-	ct90 := 0
-
-	idx := 0
-	processHours := true
+	var ct90 time.Duration
 
-	for processHours {
-		hourODI := 0
-		end := idx + 3600
+	for _, rec := range data {
+		interval := recordInterval(rec, intervals)
 
-		if end > len(data) {
-			end = len(data)
-			processHours = false
+		if hour >= time.Hour {
+			sumODI += hourODI
+			countODI++
+			hourODI = 0
+			hour = 0
 		}
+		hour += interval
 
-		for _, rec := range data[idx:end] {
-			// Throw away data that's not within reasonable physical limits
-			if rec.Pulse < 40 {
-				continue
-			}
-			if rec.Spo2 < 65 {
-				continue
-			}
+		// Skip samples flagged by the device as unreliable
+		if !rec.Valid() {
+			continue
+		}
 
-			if rec.Spo2 < 90 {
-				ct90++
-			}
+		if rec.Spo2 < 90 {
+			ct90 += interval
+		}
 
-			if avg120-float64(rec.Spo2) >= 4 {
-				log.Debugf("Oxygen desaturation event at %s: %d (%.2f 120s avg)", rec.DateTime.Format("01-02 15:04:05"), rec.Spo2, avg120)
-				hourODI++
-				if curEvent.start == nullTime {
-					curEvent.start = rec.DateTime
-					curEvent.records = append(curEvent.records, rec)
-					curEvent.avg120 = avg120
-				} else {
-					curEvent.records = append(curEvent.records, rec)
-				}
-			} else if curEvent.start != nullTime {
-				curEvent.end = rec.DateTime
-				events = append(events, curEvent)
-				curEvent = &DesaturationEvent{}
+		if avg120-float64(rec.Spo2) >= 4 {
+			log.Debugf("Oxygen desaturation event at %s: %d (%.2f 120s avg)", rec.DateTime.Format("01-02 15:04:05"), rec.Spo2, avg120)
+			hourODI += interval.Seconds()
+			if curEvent.start == nullTime {
+				curEvent.start = rec.DateTime
+				curEvent.records = append(curEvent.records, rec)
+				curEvent.avg120 = avg120
+			} else {
+				curEvent.records = append(curEvent.records, rec)
 			}
+		} else if curEvent.start != nullTime {
+			curEvent.end = rec.DateTime
+			events = append(events, curEvent)
+			curEvent = &DesaturationEvent{}
+		}
 
-			sum120 += int(rec.Spo2)
-			count120++
+		sum120 += int(rec.Spo2)
+		count120++
+		window120 += interval
 
-			if count120 == 120 {
-				avg120 = float64(sum120) / 120
-				count120 = 1
-				sum120 = 0
-				log.Debugf("Avg 120: %.2f", avg120)
-			}
+		if window120 >= 120*time.Second {
+			avg120 = float64(sum120) / float64(count120)
+			count120 = 0
+			sum120 = 0
+			window120 = 0
+			log.Debugf("Avg 120: %.2f", avg120)
 		}
-
-		idx += 3600
-		sumODI += hourODI
-		countODI++
 	}
 
-	odi := float64(sumODI) / float64(countODI)
-	log.Debugf("Hours: %d, ODI: %.2f sum: %d", countODI, odi, sumODI)
+	sumODI += hourODI
+	countODI++
+
+	odi := sumODI / float64(countODI)
+	log.Debugf("Hours: %d, ODI: %.2f sum: %.0f", countODI, odi, sumODI)
 	log.Debugf("Number of events: %d", len(events))
 	for i, ev := range events {
 		log.Debugf("Event %d: avg: %.2f start: %s end: %s", i, ev.avg120, ev.start, ev.end)
@@ -139,21 +161,19 @@ func computeODI(data []*model.OxiRecord) (float64, time.Duration, []*Desaturatio
 		}
 	}
 
-	return odi, time.Duration(time.Second * time.Duration(ct90)), events
+	return odi, ct90, events
 }
 
-func ComputeStats(records []*model.OxiRecord) *Stats {
+// ComputeStats computes summary statistics for records. sessions provides the
+// sample interval of the sessions the records belong to.
+func ComputeStats(records []*model.OxiRecord, sessions []*model.Session) *Stats {
 	var n, pulseSum, spo2Sum float64
 	stats := &Stats{}
 	stats.spo2Min, stats.pulseMin = math.MaxUint8, math.MaxUint8
 
 	for _, rec := range records {
-		// Throw away data that's not within reasonable physical limits
-		// TODO: perhaps make these configurable?
-		if rec.Pulse < 40 {
-			continue
-		}
-		if rec.Spo2 < 65 {
+		// Skip samples flagged by the device as unreliable
+		if !rec.Valid() {
 			continue
 		}
 
@@ -178,6 +198,10 @@ func ComputeStats(records []*model.OxiRecord) *Stats {
 	stats.spo2Mean = spo2Sum / n
 
 	for _, rec := range records {
+		if !rec.Valid() {
+			continue
+		}
+
 		stats.pulseSD += math.Pow(float64(rec.Pulse)-stats.pulseMean, 2)
 		stats.spo2SD += math.Pow(float64(rec.Spo2)-stats.spo2Mean, 2)
 	}
@@ -185,24 +209,70 @@ func ComputeStats(records []*model.OxiRecord) *Stats {
 	stats.pulseSD = math.Sqrt(stats.pulseSD / n)
 	stats.spo2SD = math.Sqrt(stats.spo2SD / n)
 
-	stats.odi, stats.ct90, stats.events = computeODI(records)
+	stats.odi, stats.ct90, stats.events = computeODI(records, sampleIntervals(sessions))
 	stats.totalRecords = int(n)
 	stats.badRecords = len(records) - int(n)
 
 	return stats
 }
 
-func ComputeAndPrintStats(records []*model.OxiRecord) {
-	stats := ComputeStats(records)
+// sessionZones maps session IDs to the time zone the session was recorded in
+func sessionZones(sessions []*model.Session) map[int64]*time.Location {
+	zones := make(map[int64]*time.Location, len(sessions))
+	for _, session := range sessions {
+		zones[session.ID] = session.Location()
+	}
+
+	return zones
+}
+
+// inSessionZones converts record times to the time zone of their session
+func inSessionZones(records []*model.OxiRecord, sessions []*model.Session) {
+	zones := sessionZones(sessions)
+	for _, rec := range records {
+		if loc, ok := zones[rec.SessionID]; ok {
+			rec.DateTime = rec.DateTime.In(loc)
+		}
+	}
+}
+
+// printDrift prints the device clock drift measured when the sessions of
+// records were imported and the correction applied to their start times
+func printDrift(records []*model.OxiRecord, sessions []*model.Session) {
+	ids := make(map[int64]bool)
+	for _, rec := range records {
+		ids[rec.SessionID] = true
+	}
+
+	for _, session := range sessions {
+		if !ids[session.ID] || (session.ClockDrift == 0 && session.DriftCorrection == 0) {
+			continue
+		}
+
+		drift := time.Duration(session.ClockDrift) * time.Second
+		if session.DriftCorrection == 0 {
+			fmt.Printf("Session %d Clock Drift: %s (not corrected)\n", session.ID, drift)
+			continue
+		}
+
+		correction := time.Duration(session.DriftCorrection) * time.Second
+		fmt.Printf("Session %d Clock Drift: %s (start time corrected by %s)\n", session.ID, drift, -correction)
+	}
+}
+
+func ComputeAndPrintStats(records []*model.OxiRecord, sessions []*model.Session) {
+	inSessionZones(records, sessions)
+	stats := ComputeStats(records, sessions)
 
 	fmt.Printf("------------------------------------------------------\n")
-	fmt.Printf("Start: %s End: %s\n", records[0].DateTime.Format("2006-01-02 15:04:05"), records[len(records)-1].DateTime.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Start: %s End: %s\n", records[0].DateTime.Format("2006-01-02 15:04:05 MST"), records[len(records)-1].DateTime.Format("2006-01-02 15:04:05 MST"))
 	fmt.Printf("------------------------------------------------------\n")
 	fmt.Printf("Total Records: %d (n = %d, bad data = %d)\n", len(records), stats.totalRecords, stats.badRecords)
 	fmt.Printf("Average SpO2 %%: %.2f (min: %d max: %d sd: %.2f)\n", Bold(Blue(stats.spo2Mean)), stats.spo2Min, stats.spo2Max, stats.spo2SD)
 	fmt.Printf("Average Pulse Rate: %.2f (min: %d max: %d sd: %.2f)\n", Bold(Red(stats.pulseMean)), stats.pulseMin, stats.pulseMax, stats.pulseSD)
 	fmt.Printf("ODI: %.2f\n", Bold(Blue(stats.odi)))
 	fmt.Printf("CT90: %s\n", Bold(stats.ct90))
+	printDrift(records, sessions)
 	fmt.Printf("Oxygen Desaturation Events = %d\n", len(stats.events))
 	fmt.Printf("------------------------------------------------------\n")
 	for _, e := range stats.events {
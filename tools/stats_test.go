@@ -0,0 +1,124 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+)
+
+func TestComputeStats(t *testing.T) {
+	sim := newTestSimulator(t, "sim://?duration=4h&clusters=2&dips=5&gaps=1&seed=5")
+
+	records, err := sim.GetSessionData(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start, err := sim.GetSessionTime(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, rec := range records {
+		rec.DateTime = start.Add(time.Second * time.Duration(i))
+	}
+
+	stats := ComputeStats(records, nil)
+
+	if stats.badRecords == 0 {
+		t.Errorf("Expected finger out records to be counted as bad data")
+	}
+
+	if stats.totalRecords+stats.badRecords != len(records) {
+		t.Errorf("Invalid record counts. Got %d + %d wanted %d", stats.totalRecords, stats.badRecords, len(records))
+	}
+
+	if stats.spo2Mean < 95 || stats.spo2Mean > 97 {
+		t.Errorf("Invalid mean spo2. Got %.2f wanted about %d", stats.spo2Mean, 96)
+	}
+
+	if stats.spo2Min > 92 {
+		t.Errorf("Invalid min spo2. Got %d wanted desaturations below %d", stats.spo2Min, 92)
+	}
+
+	if len(stats.events) < 5 {
+		t.Errorf("Invalid number of desaturation events. Got %d wanted at least %d", len(stats.events), 5)
+	}
+
+	if stats.odi <= 0 {
+		t.Errorf("Invalid ODI. Got %.2f wanted > 0", stats.odi)
+	}
+}
+
+func TestComputeStatsInterval(t *testing.T) {
+	start := time.Now()
+	records := make([]*model.OxiRecord, 0)
+	for i := 0; i < 1800; i++ {
+		rec := &model.OxiRecord{SessionID: 1, DateTime: start.Add(4 * time.Second * time.Duration(i)), Pulse: 60, Spo2: 96}
+		if i%100 < 10 {
+			rec.Spo2 = 88
+		}
+		records = append(records, rec)
+	}
+
+	sessions := []*model.Session{&model.Session{ID: 1, SampleInterval: 4}}
+	stats := ComputeStats(records, sessions)
+
+	// 18 dips of 10 samples 4 seconds apart
+	if stats.ct90 != 720*time.Second {
+		t.Errorf("Invalid CT90. Got %s wanted %s", stats.ct90, 720*time.Second)
+	}
+
+	// Two hours of data with 6 minutes of desaturations per hour
+	if stats.odi != 360 {
+		t.Errorf("Invalid ODI. Got %.2f wanted %d", stats.odi, 360)
+	}
+
+	stats = ComputeStats(records, nil)
+	if stats.ct90 != 180*time.Second {
+		t.Errorf("Invalid CT90 for unknown session. Got %s wanted %s", stats.ct90, 180*time.Second)
+	}
+}
+
+func TestComputeStatsFlags(t *testing.T) {
+	start := time.Now()
+	records := []*model.OxiRecord{
+		&model.OxiRecord{DateTime: start, Pulse: 35, Spo2: 95},
+		&model.OxiRecord{DateTime: start.Add(time.Second), Pulse: 45, Spo2: 97},
+		&model.OxiRecord{DateTime: start.Add(2 * time.Second), Status: model.StatusFingerOut},
+		&model.OxiRecord{DateTime: start.Add(3 * time.Second), Pulse: 120, Spo2: 60, Status: model.StatusSearching},
+	}
+
+	stats := ComputeStats(records, nil)
+
+	if stats.totalRecords != 2 || stats.badRecords != 2 {
+		t.Errorf("Invalid record counts. Got %d valid %d bad wanted %d valid %d bad", stats.totalRecords, stats.badRecords, 2, 2)
+	}
+
+	if stats.pulseMean != 40 || stats.pulseSD != 5 {
+		t.Errorf("Invalid pulse stats. Got mean %.2f sd %.2f wanted mean %d sd %d", stats.pulseMean, stats.pulseSD, 40, 5)
+	}
+
+	if stats.spo2Min != 95 || stats.spo2SD != 1 {
+		t.Errorf("Invalid spo2 stats. Got min %d sd %.2f wanted min %d sd %d", stats.spo2Min, stats.spo2SD, 95, 1)
+	}
+}
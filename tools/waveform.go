@@ -0,0 +1,67 @@
+// Copyright 2018 Andrew E. Bruno
+//
+// This file is part of myoxi.
+//
+// myoxi is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// myoxi is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with myoxi.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/aebruno/myoxi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExportWaveform writes the raw plethysmograph waveform for a session as CSV.
+// Times are written in the time zone of the session.
+func ExportWaveform(db model.Datastore, sessionID int64, out io.Writer) error {
+	session, err := db.FetchSession(sessionID)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch session %d from database: %s", sessionID, err)
+	}
+	loc := session.Location()
+
+	samples, err := db.FetchWaveformBySessionID(sessionID)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch waveform from database: %s", err)
+	}
+
+	if len(samples) == 0 {
+		return fmt.Errorf("No waveform recorded for session %d", sessionID)
+	}
+
+	log.Infof("Exporting %d waveform samples for session %d", len(samples), sessionID)
+
+	w := csv.NewWriter(out)
+	err = w.Write([]string{"date_time", "pleth"})
+	if err != nil {
+		return err
+	}
+
+	for _, sample := range samples {
+		err := w.Write([]string{sample.DateTime.In(loc).Format(time.RFC3339Nano), strconv.Itoa(int(sample.Pleth))})
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	return w.Error()
+}